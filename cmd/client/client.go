@@ -1,11 +1,13 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
 	"log"
 	"os"
+	"strings"
 
 	"github.com/urfave/cli/v2"
 	"google.golang.org/grpc"
@@ -19,7 +21,9 @@ type clientCerts struct {
 	ClientCertificate tls.Certificate
 }
 
-func loadCerts(ctx *cli.Context) (*clientCerts, error) {
+// loadCerts loads the CA certificate and, unless the client authenticates with a token, the
+// client certificate
+func loadCerts(ctx *cli.Context, token string) (*clientCerts, error) {
 	caPem, err := os.ReadFile(ctx.String("ca"))
 	if err != nil {
 		return nil, fmt.Errorf("failed to read ca.pem file: %v", err)
@@ -28,6 +32,9 @@ func loadCerts(ctx *cli.Context) (*clientCerts, error) {
 	if !certPool.AppendCertsFromPEM(caPem) {
 		return nil, fmt.Errorf("failed to add CA cert to pool: %v", err)
 	}
+	if token != "" {
+		return &clientCerts{CertPool: certPool}, nil
+	}
 	// Load client's certificate and private key
 	clientCert, err := tls.LoadX509KeyPair(ctx.String("cert"), ctx.String("key"))
 	if err != nil {
@@ -37,6 +44,42 @@ func loadCerts(ctx *cli.Context) (*clientCerts, error) {
 	return &clientCerts{certPool, clientCert}, nil
 }
 
+// loadToken returns the bearer token from --token or --token-file (empty if neither is set)
+func loadToken(ctx *cli.Context) (string, error) {
+	if ctx.String("token-file") == "" {
+		return ctx.String("token"), nil
+	}
+	token, err := os.ReadFile(ctx.String("token-file"))
+	if err != nil {
+		return "", fmt.Errorf("failed to read token file: %v", err)
+	}
+	return strings.TrimSpace(string(token)), nil
+}
+
+// bearerToken sends a token in the authorization metadata of every call
+type bearerToken string
+
+func (t bearerToken) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(t)}, nil
+}
+
+func (t bearerToken) RequireTransportSecurity() bool {
+	return true
+}
+
+// envFlag collects repeated --env flags. Unlike a cli.StringSliceFlag, values are not split on
+// commas, so variables like "OPTS=a,b" can be set.
+type envFlag []string
+
+func (e *envFlag) Set(value string) error {
+	*e = append(*e, value)
+	return nil
+}
+
+func (e *envFlag) String() string {
+	return strings.Join(*e, " ")
+}
+
 // NewClient creates and returns a new cli.App object to be run by app.Run.
 // It uses a cli.BeforeFunc (https://pkg.go.dev/github.com/urfave/cli#BeforeFunc) to create
 // the grpc connection from context values (i.e., command line paramters), then a cli.AfterFunc
@@ -50,8 +93,24 @@ func NewClient() (app *cli.App, err error) {
 	app = cli.NewApp()
 	commands := []*cli.Command{
 		{
-			Name:  "start",
-			Usage: "start a job",
+			Name:      "start",
+			Usage:     "start a job",
+			UsageText: "client start [command options] command [args...]",
+			Flags: []cli.Flag{
+				&cli.GenericFlag{
+					Name:  "env",
+					Usage: "set an environment variable (KEY=value) for the job, can be repeated",
+					Value: &envFlag{},
+				},
+				&cli.StringFlag{
+					Name:  "cwd",
+					Usage: "absolute path of the working directory of the job",
+				},
+				&cli.StringFlag{
+					Name:  "archive-url",
+					Usage: "archive the output to a sink once the job finishes (allowed by the server's --archive-allow)",
+				},
+			},
 			Action: func(c *cli.Context) error {
 				if err = Start(jobClient, c); err != nil {
 					log.Fatalf("failed starting job: %v", err)
@@ -60,9 +119,33 @@ func NewClient() (app *cli.App, err error) {
 			},
 		},
 		{
-			Name:      "stop",
-			Usage:     "stop a job",
-			UsageText: "client stop [uuid]",
+			Name:      "template",
+			Usage:     "start a job from a template defined on the server",
+			UsageText: "client template template-name [param=value...]",
+			Action: func(c *cli.Context) error {
+				if err = StartFromTemplate(jobClient, c); err != nil {
+					log.Fatalf("failed starting job: %v", err)
+				}
+				return nil
+			},
+		},
+		{
+			Name:         "restart",
+			Usage:        "start a job again with the same command, args, env and limits",
+			UsageText:    "client restart [uuid, uuid prefix or command name]",
+			BashComplete: completeJobs(&jobClient),
+			Action: func(c *cli.Context) error {
+				if err = Restart(jobClient, c); err != nil {
+					log.Fatalf("Error restarting job: %v", err)
+				}
+				return nil
+			},
+		},
+		{
+			Name:         "stop",
+			Usage:        "stop a job",
+			UsageText:    "client stop [uuid, uuid prefix or command name]",
+			BashComplete: completeJobs(&jobClient),
 			Action: func(c *cli.Context) error {
 				if err = Stop(jobClient, c); err != nil {
 					log.Fatalf("Error stopping job: %v", err)
@@ -71,9 +154,46 @@ func NewClient() (app *cli.App, err error) {
 			},
 		},
 		{
-			Name:      "status",
-			Usage:     "get status of a job",
-			UsageText: "client status [uuid]",
+			Name:         "signal",
+			Usage:        "send a signal (e.g., HUP, USR1 or TERM) to a job",
+			UsageText:    "client signal [uuid, uuid prefix or command name] signal",
+			BashComplete: completeJobs(&jobClient),
+			Action: func(c *cli.Context) error {
+				if err = Signal(jobClient, c); err != nil {
+					log.Fatalf("Error signaling job: %v", err)
+				}
+				return nil
+			},
+		},
+		{
+			Name:         "pause",
+			Usage:        "pause a job (freeze its processes) until it is resumed",
+			UsageText:    "client pause [uuid, uuid prefix or command name]",
+			BashComplete: completeJobs(&jobClient),
+			Action: func(c *cli.Context) error {
+				if err = Pause(jobClient, c, false); err != nil {
+					log.Fatalf("Error pausing job: %v", err)
+				}
+				return nil
+			},
+		},
+		{
+			Name:         "resume",
+			Usage:        "resume a paused job",
+			UsageText:    "client resume [uuid, uuid prefix or command name]",
+			BashComplete: completeJobs(&jobClient),
+			Action: func(c *cli.Context) error {
+				if err = Pause(jobClient, c, true); err != nil {
+					log.Fatalf("Error resuming job: %v", err)
+				}
+				return nil
+			},
+		},
+		{
+			Name:         "status",
+			Usage:        "get status of a job",
+			UsageText:    "client status [uuid, uuid prefix or command name]",
+			BashComplete: completeJobs(&jobClient),
 			Action: func(c *cli.Context) error {
 				if err = Status(jobClient, c); err != nil {
 					log.Fatalf("Error getting status: %v", err)
@@ -82,9 +202,32 @@ func NewClient() (app *cli.App, err error) {
 			},
 		},
 		{
-			Name:      "output",
-			Usage:     "stream output of a job",
-			UsageText: "client output [uuid]",
+			Name:         "stats",
+			Usage:        "show the resource usage (CPU time, peak memory and IO) of a job",
+			UsageText:    "client stats [uuid, uuid prefix or command name]",
+			BashComplete: completeJobs(&jobClient),
+			Action: func(c *cli.Context) error {
+				if err = Stats(jobClient, c); err != nil {
+					log.Fatalf("Error getting stats: %v", err)
+				}
+				return nil
+			},
+		},
+		{
+			Name:         "output",
+			Usage:        "stream output of a job",
+			UsageText:    "client output [command options] [uuid, uuid prefix or command name]",
+			BashComplete: completeJobs(&jobClient),
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "no-follow",
+					Usage: "print the output written so far in chunks (without a long-lived stream) and exit",
+				},
+				&cli.Int64Flag{
+					Name:  "offset",
+					Usage: "with --no-follow, byte offset in the output to start from",
+				},
+			},
 			Action: func(c *cli.Context) error {
 				if err = Output(jobClient, c); err != nil {
 					log.Fatalf("Error streaming output: %v", err)
@@ -92,6 +235,28 @@ func NewClient() (app *cli.App, err error) {
 				return nil
 			},
 		},
+		{
+			Name:         "describe",
+			Usage:        "describe the configuration of a job, including applied cgroup limits",
+			UsageText:    "client describe [uuid, uuid prefix or command name]",
+			BashComplete: completeJobs(&jobClient),
+			Action: func(c *cli.Context) error {
+				if err = Describe(jobClient, c); err != nil {
+					log.Fatalf("Error describing job: %v", err)
+				}
+				return nil
+			},
+		},
+		{
+			Name:  "list",
+			Usage: "list jobs, most recently created first",
+			Action: func(c *cli.Context) error {
+				if err = List(jobClient, c); err != nil {
+					log.Fatalf("Error listing jobs: %v", err)
+				}
+				return nil
+			},
+		},
 	}
 	flags := []cli.Flag{
 		&cli.StringFlag{
@@ -119,21 +284,56 @@ func NewClient() (app *cli.App, err error) {
 			Usage: "path to client TLS key",
 			Value: "./certs/client_admin.key",
 		},
+		&cli.StringFlag{
+			Name:    "token",
+			Usage:   "bearer token to authenticate with instead of the client certificate (for servers with --auth jwt)",
+			EnvVars: []string{"JOBMANAGER_TOKEN"},
+		},
+		&cli.StringFlag{
+			Name:  "token-file",
+			Usage: "path to a file with the bearer token to authenticate with",
+		},
+		&cli.StringFlag{
+			Name:        "config",
+			Usage:       "path to the config file with connection profiles",
+			Value:       defaultConfigPath(),
+			DefaultText: "~/.jobmanager/config.yaml",
+			EnvVars:     []string{"JOBMANAGER_CONFIG"},
+		},
+		&cli.StringFlag{
+			Name:    "profile",
+			Usage:   "profile in the config file to take unset connection flags from (the file's default profile if empty)",
+			EnvVars: []string{"JOBMANAGER_PROFILE"},
+		},
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "print results as JSON, for scripts (start, template, restart, stop, signal, pause, resume, status, stats, describe and list)",
+		},
 	}
 	// set up grpc connection before executing commands
 	app.Before = func(ctx *cli.Context) error {
-		certs, err := loadCerts(ctx)
+		if err := applyProfile(ctx); err != nil {
+			log.Fatalf("error loading profile: %v", err)
+		}
+		token, err := loadToken(ctx)
+		if err != nil {
+			log.Fatalf("error loading token: %v", err)
+		}
+		certs, err := loadCerts(ctx, token)
 		if err != nil {
 			log.Fatalf("error loading client cert: %v", err)
 		}
 
+		tlsConfig := &tls.Config{RootCAs: certs.CertPool}
+		var opts []grpc.DialOption
+		if token != "" {
+			opts = append(opts, grpc.WithPerRPCCredentials(bearerToken(token)))
+		} else {
+			tlsConfig.Certificates = []tls.Certificate{certs.ClientCertificate}
+		}
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
 		address := fmt.Sprintf("%s:%d", ctx.String("host"), ctx.Int("port"))
-		conn, err = grpc.DialContext(ctx.Context, address, grpc.WithTransportCredentials(
-			credentials.NewTLS(&tls.Config{
-				Certificates: []tls.Certificate{certs.ClientCertificate},
-				RootCAs:      certs.CertPool,
-			}),
-		))
+		conn, err = grpc.DialContext(ctx.Context, address, opts...)
 		if err != nil {
 			log.Fatalf("error connecting to %s: %v", address, err)
 		}
@@ -150,6 +350,7 @@ func NewClient() (app *cli.App, err error) {
 	}
 	app.Commands = commands
 	app.Flags = flags
+	app.EnableBashCompletion = true
 	app.Name = "client"
 	app.Usage = "grpc job manager client"
 
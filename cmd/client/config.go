@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// clientConfig is the client config file, with named profiles of connection settings:
+//
+//	default: prod
+//	profiles:
+//	  prod:
+//	    host: jobs.example.com
+//	    cert: ~/.jobmanager/prod/client.pem
+//	    key: ~/.jobmanager/prod/client.key
+//	    ca: ~/.jobmanager/prod/ca.pem
+//	  dev:
+//	    host: localhost
+//	    port: 31235
+type clientConfig struct {
+	Default  string             `yaml:"default"` // profile used when --profile isn't given
+	Profiles map[string]profile `yaml:"profiles"`
+}
+
+// profile holds values for the global flags of the same name. Paths can start with ~ or be
+// relative to the directory of the config file.
+type profile struct {
+	Host      string `yaml:"host"`
+	Port      uint   `yaml:"port"`
+	CA        string `yaml:"ca"`
+	Cert      string `yaml:"cert"`
+	Key       string `yaml:"key"`
+	TokenFile string `yaml:"token-file"`
+}
+
+// defaultConfigPath returns ~/.jobmanager/config.yaml, or an empty string if the home
+// directory is unknown
+func defaultConfigPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".jobmanager", "config.yaml")
+}
+
+// applyProfile sets the global flags that weren't given on the command line (or through the
+// environment) from the selected profile of the config file. Nothing is applied if no profile
+// is selected, and a missing config file is only an error if --profile is given.
+func applyProfile(ctx *cli.Context) error {
+	path := ctx.String("config")
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) && !ctx.IsSet("profile") && !ctx.IsSet("config") {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+	var conf clientConfig
+	if err := yaml.Unmarshal(data, &conf); err != nil {
+		return fmt.Errorf("error decoding config file %s: %v", path, err)
+	}
+
+	name := ctx.String("profile")
+	if name == "" {
+		name = conf.Default
+	}
+	if name == "" {
+		return nil
+	}
+	p, ok := conf.Profiles[name]
+	if !ok {
+		return fmt.Errorf("no profile %q in config file %s", name, path)
+	}
+
+	dir := filepath.Dir(path)
+	values := map[string]string{
+		"host":       p.Host,
+		"ca":         expandPath(p.CA, dir),
+		"cert":       expandPath(p.Cert, dir),
+		"key":        expandPath(p.Key, dir),
+		"token-file": expandPath(p.TokenFile, dir),
+	}
+	if p.Port != 0 {
+		values["port"] = strconv.FormatUint(uint64(p.Port), 10)
+	}
+	for flag, value := range values {
+		if value == "" || ctx.IsSet(flag) {
+			continue
+		}
+		if err := ctx.Set(flag, value); err != nil {
+			return fmt.Errorf("invalid %s in profile %q: %v", flag, name, err)
+		}
+	}
+	return nil
+}
+
+// expandPath expands a leading ~ to the home directory and makes relative paths relative to dir
+func expandPath(path, dir string) string {
+	if path == "" {
+		return ""
+	}
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, path[1:])
+		}
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	return path
+}
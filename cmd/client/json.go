@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/rorski/grpc-job-manager/internal/job"
+)
+
+// The types below are printed by commands run with --json. Their fields are part of the
+// client's interface for scripts, so only add to them.
+
+type startJSON struct {
+	UUID       string   `json:"uuid"`
+	Cmd        string   `json:"cmd,omitempty"`
+	Args       []string `json:"args,omitempty"`
+	Template   string   `json:"template,omitempty"`
+	ParentUUID string   `json:"parent_uuid,omitempty"`
+}
+
+type stopJSON struct {
+	UUID    string `json:"uuid"`
+	Stopped bool   `json:"stopped"`
+}
+
+type pauseJSON struct {
+	UUID   string `json:"uuid"`
+	Paused bool   `json:"paused"`
+}
+
+type signalJSON struct {
+	UUID   string `json:"uuid"`
+	Signal string `json:"signal"`
+}
+
+type statusJSON struct {
+	UUID            string     `json:"uuid"`
+	Status          string     `json:"status"`
+	Terminated      bool       `json:"terminated"`
+	ExitCode        int32      `json:"exit_code"`
+	OutputSize      int64      `json:"output_size"`
+	StartedAt       *time.Time `json:"started_at,omitempty"`
+	FinishedAt      *time.Time `json:"finished_at,omitempty"`
+	DurationSeconds float64    `json:"duration_seconds"`
+	Usage           *usageJSON `json:"usage,omitempty"`
+	ArchiveStatus   string     `json:"archive_status,omitempty"`
+	ArchiveLocation string     `json:"archive_location,omitempty"`
+	ArchiveError    string     `json:"archive_error,omitempty"`
+}
+
+type usageJSON struct {
+	CPUSeconds      float64 `json:"cpu_seconds"`
+	PeakMemoryBytes int64   `json:"peak_memory_bytes"`
+	IOReadBytes     int64   `json:"io_read_bytes"`
+	IOWriteBytes    int64   `json:"io_write_bytes"`
+}
+
+type statsJSON struct {
+	UUID   string `json:"uuid"`
+	Status string `json:"status"`
+	usageJSON
+}
+
+type jobJSON struct {
+	UUID      string    `json:"uuid"`
+	Cmd       string    `json:"cmd"`
+	Args      []string  `json:"args,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	Owner     string    `json:"owner,omitempty"`
+	Parent    string    `json:"parent_uuid,omitempty"`
+}
+
+type describeJSON struct {
+	UUID     string       `json:"uuid"`
+	Cmd      string       `json:"cmd"`
+	Args     []string     `json:"args,omitempty"`
+	Owner    string       `json:"owner,omitempty"`
+	Parent   string       `json:"parent_uuid,omitempty"`
+	Cwd      string       `json:"cwd,omitempty"`
+	EnvNames []string     `json:"env_names,omitempty"`
+	Cgroups  []cgroupJSON `json:"cgroups"`
+}
+
+type cgroupJSON struct {
+	Controller string `json:"controller"`
+	File       string `json:"file"`
+	Value      string `json:"value"`
+}
+
+// newUsageJSON converts the resource usage in a status response (nil if the job hasn't started)
+func newUsageJSON(usage *job.ResourceUsage) *usageJSON {
+	if usage == nil {
+		return nil
+	}
+	return &usageJSON{
+		CPUSeconds:      usage.GetCpuTime().AsDuration().Seconds(),
+		PeakMemoryBytes: usage.GetPeakMemoryBytes(),
+		IOReadBytes:     usage.GetIoReadBytes(),
+		IOWriteBytes:    usage.GetIoWriteBytes(),
+	}
+}
+
+// printJSON writes v to stdout as a single line of JSON
+func printJSON(v any) error {
+	return json.NewEncoder(os.Stdout).Encode(v)
+}
@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"os"
 	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/google/uuid"
@@ -25,59 +27,254 @@ func Start(jobClient job.JobManagerClient, c *cli.Context) error {
 	ctx, cancel := context.WithTimeout(c.Context, 10*time.Second)
 	defer cancel()
 
+	var env []string
+	if e, ok := c.Generic("env").(*envFlag); ok {
+		env = *e
+	}
 	res, err := jobClient.Start(ctx, &job.StartRequest{
-		Cmd:  c.Args().First(),
-		Args: c.Args().Tail(),
+		Cmd:        c.Args().First(),
+		Args:       c.Args().Tail(),
+		Env:        env,
+		Cwd:        c.String("cwd"),
+		ArchiveUrl: c.String("archive-url"),
 	})
 	if err != nil {
 		return err
 	}
+	if c.Bool("json") {
+		return printJSON(startJSON{UUID: res.Uuid, Cmd: c.Args().First(), Args: c.Args().Tail()})
+	}
 	fmt.Printf("Started job: %q\nUUID: %s\n", strings.Join(c.Args().Slice(), " "), res.Uuid)
 	return nil
 }
 
-func Stop(jobClient job.JobManagerClient, c *cli.Context) error {
-	uuid := c.Args().First()
-	if !validateUUID(uuid) {
-		return fmt.Errorf("could not parse uuid: %s", uuid)
+// StartFromTemplate starts a job from a server-side template, with params given as param=value
+func StartFromTemplate(jobClient job.JobManagerClient, c *cli.Context) error {
+	ctx, cancel := context.WithTimeout(c.Context, 10*time.Second)
+	defer cancel()
+
+	params := make(map[string]string)
+	for _, arg := range c.Args().Tail() {
+		name, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return fmt.Errorf("param %q is not in param=value form", arg)
+		}
+		params[name] = value
+	}
+	res, err := jobClient.StartFromTemplate(ctx, &job.StartFromTemplateRequest{Template: c.Args().First(), Params: params})
+	if err != nil {
+		return err
+	}
+	if c.Bool("json") {
+		return printJSON(startJSON{UUID: res.Uuid, Template: c.Args().First()})
 	}
+	fmt.Printf("Started job from template %q\nUUID: %s\n", c.Args().First(), res.Uuid)
+	return nil
+}
 
+// Restart starts a job again with the same command, args, env and limits
+func Restart(jobClient job.JobManagerClient, c *cli.Context) error {
 	ctx, cancel := context.WithTimeout(c.Context, 10*time.Second)
 	defer cancel()
 
-	if _, err := jobClient.Stop(ctx, &job.StopRequest{Uuid: uuid}); err != nil {
+	uuid, err := resolveJob(ctx, jobClient, c.Args().First())
+	if err != nil {
+		return err
+	}
+
+	res, err := jobClient.Restart(ctx, &job.RestartRequest{Uuid: uuid})
+	if err != nil {
 		return err
 	}
+	if c.Bool("json") {
+		return printJSON(startJSON{UUID: res.Uuid, ParentUUID: uuid})
+	}
+	fmt.Printf("Restarted job: %s\nUUID: %s\n", uuid, res.Uuid)
+	return nil
+}
+
+func Stop(jobClient job.JobManagerClient, c *cli.Context) error {
+	ctx, cancel := context.WithTimeout(c.Context, 10*time.Second)
+	defer cancel()
+
+	uuid, err := resolveJob(ctx, jobClient, c.Args().First())
+	if err != nil {
+		return err
+	}
+
+	if _, err = jobClient.Stop(ctx, &job.StopRequest{Uuid: uuid}); err != nil {
+		return err
+	}
+	if c.Bool("json") {
+		return printJSON(stopJSON{UUID: uuid, Stopped: true})
+	}
 	fmt.Printf("Stopped job: %s\n", uuid)
 	return nil
 }
 
-func Status(jobClient job.JobManagerClient, c *cli.Context) error {
-	uuid := c.Args().First()
-	if !validateUUID(uuid) {
-		return fmt.Errorf("could not parse uuid: %s", uuid)
+// Signal sends a signal (e.g., HUP) to a job
+func Signal(jobClient job.JobManagerClient, c *cli.Context) error {
+	ctx, cancel := context.WithTimeout(c.Context, 10*time.Second)
+	defer cancel()
+
+	if c.NArg() != 2 {
+		return fmt.Errorf("expected a job and a signal")
+	}
+	uuid, err := resolveJob(ctx, jobClient, c.Args().First())
+	if err != nil {
+		return err
+	}
+
+	sig := c.Args().Get(1)
+	if _, err = jobClient.Signal(ctx, &job.SignalRequest{Uuid: uuid, Signal: sig}); err != nil {
+		return err
+	}
+	if c.Bool("json") {
+		return printJSON(signalJSON{UUID: uuid, Signal: sig})
 	}
+	fmt.Printf("Sent %s to job: %s\n", sig, uuid)
+	return nil
+}
 
+// Pause pauses a job, or resumes it if resume is set
+func Pause(jobClient job.JobManagerClient, c *cli.Context, resume bool) error {
 	ctx, cancel := context.WithTimeout(c.Context, 10*time.Second)
 	defer cancel()
 
+	uuid, err := resolveJob(ctx, jobClient, c.Args().First())
+	if err != nil {
+		return err
+	}
+
+	if resume {
+		_, err = jobClient.Resume(ctx, &job.ResumeRequest{Uuid: uuid})
+	} else {
+		_, err = jobClient.Pause(ctx, &job.PauseRequest{Uuid: uuid})
+	}
+	if err != nil {
+		return err
+	}
+	if c.Bool("json") {
+		return printJSON(pauseJSON{UUID: uuid, Paused: !resume})
+	}
+	if resume {
+		fmt.Printf("Resumed job: %s\n", uuid)
+	} else {
+		fmt.Printf("Paused job: %s\n", uuid)
+	}
+	return nil
+}
+
+func Status(jobClient job.JobManagerClient, c *cli.Context) error {
+	ctx, cancel := context.WithTimeout(c.Context, 10*time.Second)
+	defer cancel()
+
+	uuid, err := resolveJob(ctx, jobClient, c.Args().First())
+	if err != nil {
+		return err
+	}
+
 	res, err := jobClient.Status(ctx, &job.StatusRequest{Uuid: uuid})
 	if err != nil {
 		return err
 	}
+	startedAt, finishedAt, duration := res.GetStartedAt(), res.GetFinishedAt(), res.GetDuration()
+	if c.Bool("json") {
+		out := statusJSON{
+			UUID:            uuid,
+			Status:          res.GetStatus(),
+			Terminated:      res.GetTerminated(),
+			ExitCode:        res.GetExitCode(),
+			OutputSize:      res.GetOutputSize(),
+			ArchiveStatus:   res.GetArchiveStatus(),
+			ArchiveLocation: res.GetArchiveLocation(),
+			ArchiveError:    res.GetArchiveError(),
+			DurationSeconds: duration.AsDuration().Seconds(),
+			Usage:           newUsageJSON(res.GetUsage()),
+		}
+		if startedAt != nil {
+			t := startedAt.AsTime()
+			out.StartedAt = &t
+		}
+		if finishedAt != nil {
+			t := finishedAt.AsTime()
+			out.FinishedAt = &t
+		}
+		return printJSON(out)
+	}
+	// print the times separately, rather than as nested messages (usage is shown by stats)
+	res.StartedAt, res.FinishedAt, res.Duration, res.Usage = nil, nil, nil, nil
 	fmt.Printf("Status of job: [%+v]\n", res)
+	if startedAt != nil {
+		fmt.Printf("Started: %s\n", startedAt.AsTime().Local().Format("2006-01-02 15:04:05"))
+	}
+	if finishedAt != nil {
+		fmt.Printf("Finished: %s\n", finishedAt.AsTime().Local().Format("2006-01-02 15:04:05"))
+	}
+	if duration != nil {
+		fmt.Printf("Duration: %s\n", duration.AsDuration().Round(time.Millisecond))
+	}
 	return nil
 }
 
-func Output(jobClient job.JobManagerClient, c *cli.Context) error {
-	uuid := c.Args().First()
-	if !validateUUID(uuid) {
-		return fmt.Errorf("could not parse uuid: %s", uuid)
+// Stats prints the resource usage of a job, so far if it is still running
+func Stats(jobClient job.JobManagerClient, c *cli.Context) error {
+	ctx, cancel := context.WithTimeout(c.Context, 10*time.Second)
+	defer cancel()
+
+	uuid, err := resolveJob(ctx, jobClient, c.Args().First())
+	if err != nil {
+		return err
 	}
 
+	res, err := jobClient.Status(ctx, &job.StatusRequest{Uuid: uuid})
+	if err != nil {
+		return err
+	}
+	if res.GetUsage() == nil {
+		return fmt.Errorf("job %s has not started yet", uuid)
+	}
+	usage := newUsageJSON(res.GetUsage())
+	if c.Bool("json") {
+		return printJSON(statsJSON{UUID: uuid, Status: res.GetStatus(), usageJSON: *usage})
+	}
+	fmt.Printf("UUID: %s\nStatus: %s\n", uuid, res.GetStatus())
+	fmt.Printf("CPU time: %s\n", res.GetUsage().GetCpuTime().AsDuration().Round(time.Millisecond))
+	fmt.Printf("Peak memory: %s\n", formatBytes(usage.PeakMemoryBytes))
+	fmt.Printf("IO read: %s\n", formatBytes(usage.IOReadBytes))
+	fmt.Printf("IO written: %s\n", formatBytes(usage.IOWriteBytes))
+	return nil
+}
+
+// formatBytes formats a number of bytes with a binary unit (e.g., 1.5 MiB)
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func Output(jobClient job.JobManagerClient, c *cli.Context) error {
 	ctx, cancel := context.WithCancel(c.Context)
 	defer cancel()
 
+	uuid, err := resolveJob(ctx, jobClient, c.Args().First())
+	if err != nil {
+		return err
+	}
+
+	if c.Bool("no-follow") {
+		return outputChunks(ctx, jobClient, uuid, c.Int64("offset"))
+	} else if c.IsSet("offset") {
+		return fmt.Errorf("--offset requires --no-follow")
+	}
+
 	stream, err := jobClient.Output(ctx, &job.OutputRequest{Uuid: uuid})
 	if err != nil {
 		log.Fatalf("Error streaming output: %v", err)
@@ -96,3 +293,103 @@ func Output(jobClient job.JobManagerClient, c *cli.Context) error {
 
 	return nil
 }
+
+// outputChunks prints the output of a job from offset with GetOutput calls, until it has read
+// everything written so far. If the job is still running, the offset to continue from is
+// printed to stderr.
+func outputChunks(ctx context.Context, jobClient job.JobManagerClient, uuid string, offset int64) error {
+	for {
+		res, err := jobClient.GetOutput(ctx, &job.GetOutputRequest{Uuid: uuid, Offset: offset})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s", res.GetOutput())
+		offset = res.GetNextOffset()
+		if res.GetEof() {
+			return nil
+		}
+		if len(res.GetOutput()) == 0 {
+			fmt.Fprintf(os.Stderr, "job is still running, continue with --offset %d\n", offset)
+			return nil
+		}
+	}
+}
+
+func Describe(jobClient job.JobManagerClient, c *cli.Context) error {
+	ctx, cancel := context.WithTimeout(c.Context, 10*time.Second)
+	defer cancel()
+
+	uuid, err := resolveJob(ctx, jobClient, c.Args().First())
+	if err != nil {
+		return err
+	}
+
+	res, err := jobClient.GetJob(ctx, &job.GetJobRequest{Uuid: uuid})
+	if err != nil {
+		return err
+	}
+	if c.Bool("json") {
+		out := describeJSON{
+			UUID:     res.GetUuid(),
+			Cmd:      res.GetCmd(),
+			Args:     res.GetArgs(),
+			Owner:    res.GetOwner(),
+			Parent:   res.GetParentUuid(),
+			Cwd:      res.GetCwd(),
+			EnvNames: res.GetEnvNames(),
+			Cgroups:  []cgroupJSON{},
+		}
+		for _, param := range res.GetCgroups() {
+			out.Cgroups = append(out.Cgroups, cgroupJSON{Controller: param.GetController(), File: param.GetFile(), Value: param.GetValue()})
+		}
+		return printJSON(out)
+	}
+	fmt.Printf("UUID: %s\nCommand: %q\n", res.GetUuid(), strings.Join(append([]string{res.GetCmd()}, res.GetArgs()...), " "))
+	if res.GetOwner() != "" {
+		fmt.Printf("Owner: %s\n", res.GetOwner())
+	}
+	if res.GetParentUuid() != "" {
+		fmt.Printf("Restarted from: %s\n", res.GetParentUuid())
+	}
+	if res.GetCwd() != "" {
+		fmt.Printf("Working directory: %s\n", res.GetCwd())
+	}
+	if len(res.GetEnvNames()) > 0 {
+		fmt.Printf("Environment: %s\n", strings.Join(res.GetEnvNames(), ", "))
+	}
+	fmt.Println("Cgroups:")
+	for _, param := range res.GetCgroups() {
+		fmt.Printf("  %s/%s: %s\n", param.GetController(), param.GetFile(), param.GetValue())
+	}
+	return nil
+}
+
+func List(jobClient job.JobManagerClient, c *cli.Context) error {
+	ctx, cancel := context.WithTimeout(c.Context, 10*time.Second)
+	defer cancel()
+
+	res, err := jobClient.List(ctx, &job.ListRequest{})
+	if err != nil {
+		return err
+	}
+	if c.Bool("json") {
+		jobs := []jobJSON{}
+		for _, j := range res.GetJobs() {
+			jobs = append(jobs, jobJSON{
+				UUID:      j.GetUuid(),
+				Cmd:       j.GetCmd(),
+				Args:      j.GetArgs(),
+				CreatedAt: j.GetCreatedAt().AsTime(),
+				Owner:     j.GetOwner(),
+				Parent:    j.GetParentUuid(),
+			})
+		}
+		return printJSON(jobs)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "UUID\tCREATED\tOWNER\tCOMMAND")
+	for _, j := range res.GetJobs() {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", j.GetUuid(), j.GetCreatedAt().AsTime().Local().Format("2006-01-02 15:04:05"), j.GetOwner(), commandLine(j))
+	}
+	return w.Flush()
+}
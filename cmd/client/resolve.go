@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/rorski/grpc-job-manager/internal/job"
+)
+
+// resolveJob resolves a job reference given on the command line to a job UUID. A full UUID is
+// used as is; otherwise the reference can be a unique UUID prefix (like docker) or the name of
+// the command the job runs (e.g., "ps" for "/bin/ps aux"). If several jobs match, an error
+// lists them, most recently created first.
+func resolveJob(ctx context.Context, jobClient job.JobManagerClient, ref string) (string, error) {
+	if validateUUID(ref) {
+		return ref, nil
+	}
+	if ref == "" {
+		return "", fmt.Errorf("missing job uuid")
+	}
+
+	res, err := jobClient.List(ctx, &job.ListRequest{})
+	if err != nil {
+		return "", fmt.Errorf("error listing jobs: %v", err)
+	}
+	// the server returns jobs most recently created first, so the candidates are ranked by recency
+	var candidates []*job.JobSummary
+	for _, j := range res.GetJobs() {
+		if strings.HasPrefix(j.GetUuid(), ref) || filepath.Base(j.GetCmd()) == ref {
+			candidates = append(candidates, j)
+		}
+	}
+
+	switch len(candidates) {
+	case 0:
+		return "", fmt.Errorf("no job matching %q", ref)
+	case 1:
+		return candidates[0].GetUuid(), nil
+	}
+	var matches []string
+	for _, j := range candidates {
+		matches = append(matches, fmt.Sprintf("  %s  %s", j.GetUuid(), commandLine(j)))
+	}
+	return "", fmt.Errorf("%q matches %d jobs (most recent first):\n%s", ref, len(candidates), strings.Join(matches, "\n"))
+}
+
+// completeJobs is a cli.BashCompleteFunc printing the UUIDs of jobs on the server, most recently
+// created first. For zsh, each UUID is described by the command line of the job.
+func completeJobs(jobClient *job.JobManagerClient) cli.BashCompleteFunc {
+	return func(c *cli.Context) {
+		// only complete the first argument
+		if c.NArg() > 0 || *jobClient == nil {
+			return
+		}
+		ctx, cancel := context.WithTimeout(c.Context, 2*time.Second)
+		defer cancel()
+
+		res, err := (*jobClient).List(ctx, &job.ListRequest{})
+		if err != nil {
+			return
+		}
+		_, zsh := os.LookupEnv("_CLI_ZSH_AUTOCOMPLETE_HACK")
+		for _, j := range res.GetJobs() {
+			if zsh {
+				fmt.Fprintf(c.App.Writer, "%s:%s\n", j.GetUuid(), strings.ReplaceAll(commandLine(j), ":", `\:`))
+			} else {
+				fmt.Fprintln(c.App.Writer, j.GetUuid())
+			}
+		}
+	}
+}
+
+// commandLine returns the command and arguments of a job as a single string
+func commandLine(j *job.JobSummary) string {
+	return strings.Join(append([]string{j.GetCmd()}, j.GetArgs()...), " ")
+}
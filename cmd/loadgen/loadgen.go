@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rorski/grpc-job-manager/internal/job"
+)
+
+// operations driven by loadgen, in the order they are reported
+const (
+	opStart  = "start"
+	opStatus = "status"
+	opOutput = "output"
+)
+
+var operations = []string{opStart, opStatus, opOutput}
+
+// loadGen issues calls to the server at fixed rates. Status and Output calls target jobs
+// previously started by loadgen, picked at random.
+type loadGen struct {
+	client      job.JobManagerClient
+	cmd         string
+	args        []string
+	timeout     time.Duration // timeout of a single call
+	maxInflight int           // maximum calls in flight per operation
+
+	mu    sync.Mutex
+	uuids []string // jobs started so far
+}
+
+// result holds the measurements for one operation
+type result struct {
+	mu        sync.Mutex
+	latencies []time.Duration // latencies of successful calls
+	errors    int
+	dropped   int // calls not issued because maxInflight calls were already in flight
+	lastErr   error
+}
+
+func (r *result) record(latency time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		r.errors++
+		r.lastErr = err
+		return
+	}
+	r.latencies = append(r.latencies, latency)
+}
+
+// run generates load for duration at the given rates (calls per second, by operation) and
+// returns the results by operation once all calls in flight are done
+func (lg *loadGen) run(ctx context.Context, duration time.Duration, rates map[string]float64) map[string]*result {
+	ctx, cancel := context.WithTimeout(ctx, duration)
+	defer cancel()
+
+	results := make(map[string]*result, len(operations))
+	var wg sync.WaitGroup
+	for _, op := range operations {
+		results[op] = &result{}
+		if rates[op] <= 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(op string) {
+			defer wg.Done()
+			lg.drive(ctx, op, rates[op], results[op])
+		}(op)
+	}
+	wg.Wait()
+	return results
+}
+
+// drive issues calls for an operation at a fixed rate until the context is done, then waits
+// for the calls in flight
+func (lg *loadGen) drive(ctx context.Context, op string, rate float64, res *result) {
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / rate))
+	defer ticker.Stop()
+	inflight := make(chan struct{}, lg.maxInflight)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		select {
+		case inflight <- struct{}{}:
+		default:
+			res.mu.Lock()
+			res.dropped++
+			res.mu.Unlock()
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-inflight
+				wg.Done()
+			}()
+			// calls in flight are allowed to finish after the load duration is over
+			callCtx, cancel := context.WithTimeout(context.Background(), lg.timeout)
+			defer cancel()
+			begin := time.Now()
+			called, err := lg.call(callCtx, op)
+			if called {
+				res.record(time.Since(begin), err)
+			}
+		}()
+	}
+}
+
+// call issues a single call for an operation. It returns false if there was nothing to call
+// (i.e., no job has been started yet for Status or Output).
+func (lg *loadGen) call(ctx context.Context, op string) (bool, error) {
+	if op == opStart {
+		res, err := lg.client.Start(ctx, &job.StartRequest{Cmd: lg.cmd, Args: lg.args})
+		if err == nil {
+			lg.mu.Lock()
+			lg.uuids = append(lg.uuids, res.GetUuid())
+			lg.mu.Unlock()
+		}
+		return true, err
+	}
+
+	lg.mu.Lock()
+	if len(lg.uuids) == 0 {
+		lg.mu.Unlock()
+		return false, nil
+	}
+	uuid := lg.uuids[rand.Intn(len(lg.uuids))]
+	lg.mu.Unlock()
+
+	switch op {
+	case opStatus:
+		_, err := lg.client.Status(ctx, &job.StatusRequest{Uuid: uuid})
+		return true, err
+	case opOutput:
+		// read the whole stream, which ends once the job has exited and its output is sent
+		stream, err := lg.client.Output(ctx, &job.OutputRequest{Uuid: uuid})
+		if err != nil {
+			return true, err
+		}
+		for {
+			if _, err := stream.Recv(); err != nil {
+				if err == io.EOF {
+					return true, nil
+				}
+				return true, err
+			}
+		}
+	}
+	return false, fmt.Errorf("unknown operation %q", op)
+}
+
+// report writes a table of call counts and latency percentiles by operation
+func report(out io.Writer, results map[string]*result, duration time.Duration) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "OP\tOK\tERRORS\tDROPPED\tRATE/S\tP50\tP90\tP99\tMAX")
+	for _, op := range operations {
+		res := results[op]
+		sort.Slice(res.latencies, func(i, j int) bool { return res.latencies[i] < res.latencies[j] })
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%.1f\t%v\t%v\t%v\t%v\n", op, len(res.latencies), res.errors, res.dropped,
+			float64(len(res.latencies))/duration.Seconds(),
+			percentile(res.latencies, 50), percentile(res.latencies, 90), percentile(res.latencies, 99), percentile(res.latencies, 100))
+	}
+	w.Flush()
+	for _, op := range operations {
+		if err := results[op].lastErr; err != nil {
+			fmt.Fprintf(out, "last %s error: %v\n", op, err)
+		}
+	}
+}
+
+// percentile returns the p-th percentile of sorted latencies (nearest rank), rounded for display
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	} else if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank].Round(10 * time.Microsecond)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/rorski/grpc-job-manager/internal/job"
+)
+
+func main() {
+	app := cli.NewApp()
+	app.Name = "loadgen"
+	app.Usage = "drive a grpc job manager server with Start, Status and Output calls and report latencies"
+	app.UsageText = "loadgen [global options] [command [args...]] (the job to start, default: echo loadgen)"
+	app.Flags = []cli.Flag{
+		&cli.StringFlag{
+			Name:  "host",
+			Usage: "gRPC host address",
+			Value: "localhost",
+		},
+		&cli.UintFlag{
+			Name:  "port",
+			Usage: "gRPC port",
+			Value: 31234,
+		},
+		&cli.StringFlag{
+			Name:  "ca",
+			Usage: "path to CA certificate",
+			Value: "./certs/ca.pem",
+		},
+		&cli.StringFlag{
+			Name:  "cert",
+			Usage: "path to client TLS certificate (needs the admin role to start jobs)",
+			Value: "./certs/client_admin.pem",
+		},
+		&cli.StringFlag{
+			Name:  "key",
+			Usage: "path to client TLS key",
+			Value: "./certs/client_admin.key",
+		},
+		&cli.DurationFlag{
+			Name:  "duration",
+			Usage: "how long to generate load for",
+			Value: 30 * time.Second,
+		},
+		&cli.Float64Flag{
+			Name:  "start-rate",
+			Usage: "Start calls per second (0 to disable)",
+			Value: 1,
+		},
+		&cli.Float64Flag{
+			Name:  "status-rate",
+			Usage: "Status calls per second on jobs started by loadgen (0 to disable)",
+			Value: 10,
+		},
+		&cli.Float64Flag{
+			Name:  "output-rate",
+			Usage: "Output streams per second on jobs started by loadgen (0 to disable)",
+			Value: 1,
+		},
+		&cli.DurationFlag{
+			Name:  "timeout",
+			Usage: "timeout of each call, including reading a whole Output stream",
+			Value: 10 * time.Second,
+		},
+		&cli.IntFlag{
+			Name:  "max-inflight",
+			Usage: "maximum number of calls in flight per operation; calls over the limit are dropped",
+			Value: 100,
+		},
+	}
+	app.Action = func(c *cli.Context) error {
+		conn, err := dial(c)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		cmd, args := "echo", []string{"loadgen"}
+		if c.NArg() > 0 {
+			cmd, args = c.Args().First(), c.Args().Tail()
+		}
+		lg := &loadGen{
+			client:      job.NewJobManagerClient(conn),
+			cmd:         cmd,
+			args:        args,
+			timeout:     c.Duration("timeout"),
+			maxInflight: c.Int("max-inflight"),
+		}
+		rates := map[string]float64{
+			opStart:  c.Float64("start-rate"),
+			opStatus: c.Float64("status-rate"),
+			opOutput: c.Float64("output-rate"),
+		}
+		log.Printf("generating load for %v", c.Duration("duration"))
+		results := lg.run(c.Context, c.Duration("duration"), rates)
+		report(os.Stdout, results, c.Duration("duration"))
+		return nil
+	}
+
+	if err := app.Run(os.Args); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// dial creates the grpc connection to the server with the client certificate
+func dial(c *cli.Context) (*grpc.ClientConn, error) {
+	caPem, err := os.ReadFile(c.String("ca"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ca.pem file: %v", err)
+	}
+	certPool := x509.NewCertPool()
+	if !certPool.AppendCertsFromPEM(caPem) {
+		return nil, fmt.Errorf("failed to add CA cert to pool")
+	}
+	clientCert, err := tls.LoadX509KeyPair(c.String("cert"), c.String("key"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the client certificates: %v", err)
+	}
+
+	address := fmt.Sprintf("%s:%d", c.String("host"), c.Uint("port"))
+	conn, err := grpc.DialContext(c.Context, address, grpc.WithTransportCredentials(
+		credentials.NewTLS(&tls.Config{
+			Certificates: []tls.Certificate{clientCert},
+			RootCAs:      certPool,
+		}),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to %s: %v", address, err)
+	}
+	return conn, nil
+}
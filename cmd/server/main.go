@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"time"
 
 	"github.com/rorski/grpc-job-manager/internal/api"
 	"github.com/rorski/grpc-job-manager/worker"
@@ -41,14 +42,132 @@ func main() {
 			Usage: "IP to listen on",
 			Value: "localhost",
 		},
+		&cli.IntFlag{
+			Name:  "max-jobs",
+			Usage: "maximum number of concurrently running jobs (0 for no limit)",
+			Value: 0,
+		},
+		&cli.BoolFlag{
+			Name:  "queue-jobs",
+			Usage: "queue jobs over the max-jobs limit instead of rejecting them",
+		},
+		&cli.BoolFlag{
+			Name:  "sync-output",
+			Usage: "flush job output to disk before reporting jobs as exited",
+		},
+		&cli.StringFlag{
+			Name:  "audit-log",
+			Usage: "path to an append-only audit log (JSON lines) of all RPCs",
+		},
+		&cli.StringFlag{
+			Name:  "crl",
+			Usage: "path to a certificate revocation list (PEM or DER) signed by the CA",
+		},
+		&cli.DurationFlag{
+			Name:  "crl-reload",
+			Usage: "how often to reload the CRL (0 to disable)",
+			Value: 5 * time.Minute,
+		},
+		&cli.StringFlag{
+			Name:  "output-watcher",
+			Usage: "how to follow job output: inotify, poll, or auto (inotify, falling back to polling)",
+			Value: "auto",
+		},
+		&cli.StringSliceFlag{
+			Name:  "env-deny",
+			Usage: "patterns (e.g., LD_*) of environment variables jobs can't set",
+			Value: cli.NewStringSlice(worker.DefaultEnvDeny...),
+		},
+		&cli.StringSliceFlag{
+			Name:  "env-allow",
+			Usage: "if set, patterns of the only environment variables jobs can set",
+		},
+		&cli.StringSliceFlag{
+			Name:  "command-deny",
+			Usage: "patterns (e.g., /usr/bin/rm) of the absolute paths of commands jobs can't run",
+		},
+		&cli.StringSliceFlag{
+			Name:  "command-allow",
+			Usage: "if set, patterns (e.g., /usr/local/scripts/*) of the absolute paths of the only commands jobs can run",
+		},
+		&cli.StringFlag{
+			Name:  "archive-url",
+			Usage: "archive the output of every finished job to a sink: file:///dir, http(s)://url or s3://bucket/prefix",
+		},
+		&cli.StringSliceFlag{
+			Name:  "archive-allow",
+			Usage: "URL prefixes of the archive sinks clients can choose per job (none if not set)",
+		},
+		&cli.StringFlag{
+			Name:  "templates",
+			Usage: "path to a JSON file of job templates, which clients with the user role can start by name",
+		},
+		&cli.StringFlag{
+			Name:  "auth",
+			Usage: "how clients authenticate: mtls (client certificates) or jwt (bearer tokens, see the --jwt-* flags)",
+			Value: "mtls",
+		},
+		&cli.StringFlag{
+			Name:  "jwt-issuer",
+			Usage: "issuer of client tokens, checked against the iss claim (its JWKS is discovered from /.well-known/openid-configuration)",
+		},
+		&cli.StringFlag{
+			Name:  "jwt-jwks-url",
+			Usage: "URL of the JWKS with the keys client tokens are signed with (discovered from --jwt-issuer if not set)",
+		},
+		&cli.StringFlag{
+			Name:  "jwt-audience",
+			Usage: "audience required in the aud claim of client tokens",
+		},
+		&cli.StringFlag{
+			Name:  "jwt-identity-claim",
+			Usage: "token claim with the identity of the client",
+			Value: "sub",
+		},
+		&cli.StringFlag{
+			Name:  "jwt-role-claim",
+			Usage: "token claim with the role of the client (a string, or an array of roles; nested claims like realm_access.roles are supported)",
+			Value: "role",
+		},
+		&cli.DurationFlag{
+			Name:  "drain-timeout",
+			Usage: "on shutdown, how long to wait for running jobs to finish before stopping them",
+			Value: 30 * time.Second,
+		},
+		&cli.StringFlag{
+			Name:  "drain-state",
+			Usage: "on shutdown, path to save the configuration and status of all jobs to (JSON)",
+		},
 	}
 	app.Action = func(ctx *cli.Context) error {
 		conf := api.Config{
-			Host:        ctx.String("host"),
-			Port:        ctx.Int("port"),
-			Certificate: ctx.String("cert"),
-			Key:         ctx.String("key"),
-			CA:          ctx.String("ca"),
+			Host:               ctx.String("host"),
+			Port:               ctx.Int("port"),
+			Certificate:        ctx.String("cert"),
+			Key:                ctx.String("key"),
+			CA:                 ctx.String("ca"),
+			MaxJobs:            ctx.Int("max-jobs"),
+			QueueJobs:          ctx.Bool("queue-jobs"),
+			SyncOutput:         ctx.Bool("sync-output"),
+			AuditLog:           ctx.String("audit-log"),
+			CRL:                ctx.String("crl"),
+			CRLReload:          ctx.Duration("crl-reload"),
+			DrainTimeout:       ctx.Duration("drain-timeout"),
+			DrainState:         ctx.String("drain-state"),
+			OutputWatcher:      ctx.String("output-watcher"),
+			EnvDeny:            ctx.StringSlice("env-deny"),
+			EnvAllow:           ctx.StringSlice("env-allow"),
+			ArchiveURL:         ctx.String("archive-url"),
+			ArchiveAllow:       ctx.StringSlice("archive-allow"),
+			CommandDeny:        ctx.StringSlice("command-deny"),
+			CommandAllow:       ctx.StringSlice("command-allow"),
+			Templates:          ctx.String("templates"),
+			Auth:               ctx.String("auth"),
+			TokenIssuer:        ctx.String("jwt-issuer"),
+			TokenJWKS:          ctx.String("jwt-jwks-url"),
+			TokenAudience:      ctx.String("jwt-audience"),
+			TokenIdentityClaim: ctx.String("jwt-identity-claim"),
+			TokenRoleClaim:     ctx.String("jwt-role-claim"),
 		}
 
 		if err := api.Serve(conf); err != nil {
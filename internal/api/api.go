@@ -2,74 +2,313 @@ package api
 
 import (
 	"context"
-	"fmt"
 	"log"
+	"sort"
+	"strings"
 
 	"github.com/rorski/grpc-job-manager/internal/job"
 	"github.com/rorski/grpc-job-manager/worker"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
+// jobManagerServer implements the JobManager service. Errors are returned as gRPC statuses (see
+// statusError), and unknown UUIDs return NOT_FOUND in every method.
 type jobManagerServer struct {
 	job.UnimplementedJobManagerServer
-	Worker worker.Worker
+	Worker    worker.Worker
+	templates map[string]*jobTemplate // job templates by name (see StartFromTemplate)
 }
 
 // Start takes a linux command with arguments to run on the worker.
 // If successful, it returns the UUID, which can be used to reference the job for other methods (stop, status, and output).
+// If the worker's job limit is reached and queueing is disabled, it returns RESOURCE_EXHAUSTED.
+// If the server is draining before shutting down, it returns UNAVAILABLE.
+// Environment variables denied by the server, or a relative cwd, return INVALID_ARGUMENT.
+// Commands not allowed by the server's command policy return PERMISSION_DENIED.
 //
 // Roles: [admin]
 func (s *jobManagerServer) Start(c context.Context, in *job.StartRequest) (*job.StartResponse, error) {
-	res, err := s.Worker.Start(in.GetCmd(), in.GetArgs())
+	id, _ := identityFromContext(c)
+	res, err := s.Worker.Start(in.GetCmd(), in.GetArgs(), worker.WithOwner(id.CN), worker.WithEnv(in.GetEnv()),
+		worker.WithDir(in.GetCwd()), worker.WithArchive(in.GetArchiveUrl()))
+	if err != nil {
+		return nil, statusError(err, "error starting job")
+	}
+	return &job.StartResponse{Uuid: res}, nil
+}
+
+// StartFromTemplate starts a job from a template defined by admins on the server, filling in its
+// ${param} placeholders from the params of the request. Unknown templates return NOT_FOUND, and
+// missing, unknown or invalid params return INVALID_ARGUMENT. Otherwise it returns the same errors
+// as Start.
+//
+// Roles: [admin, user]
+func (s *jobManagerServer) StartFromTemplate(c context.Context, in *job.StartFromTemplateRequest) (*job.StartResponse, error) {
+	t, ok := s.templates[in.GetTemplate()]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "no job template named %q", in.GetTemplate())
+	}
+	args, env, err := t.expand(in.GetParams())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "error starting job: %v", err)
+	}
+	id, _ := identityFromContext(c)
+	res, err := s.Worker.Start(t.Cmd, args, worker.WithOwner(id.CN), worker.WithEnv(env), worker.WithDir(t.Cwd),
+		worker.WithCgroups(t.Cgroups))
 	if err != nil {
-		return nil, fmt.Errorf("error starting job: %v", err)
+		return nil, statusError(err, "error starting job")
 	}
 	return &job.StartResponse{Uuid: res}, nil
 }
 
-// Stop takes a UUID and stops the job, if it is still running.
+// Restart takes a UUID and starts a new job with the same command, args, env, working directory
+// and cgroup limits, owned by the client. It returns the UUID of the new job, which links back
+// to the existing one with its parent UUID, or the same errors as Start.
+//
+// Roles: [admin]
+func (s *jobManagerServer) Restart(c context.Context, in *job.RestartRequest) (*job.StartResponse, error) {
+	if err := s.checkAccess(c, in.GetUuid()); err != nil {
+		return nil, err
+	}
+	id, _ := identityFromContext(c)
+	res, err := s.Worker.Restart(in.GetUuid(), worker.WithOwner(id.CN))
+	if err != nil {
+		return nil, statusError(err, "error restarting job")
+	}
+	return &job.StartResponse{Uuid: res}, nil
+}
+
+// Signal takes a UUID and sends a signal to the job, if it is still running. Unknown signals
+// return INVALID_ARGUMENT, and queued or exited jobs FAILED_PRECONDITION.
+//
+// Roles: [admin]
+func (s *jobManagerServer) Signal(c context.Context, in *job.SignalRequest) (*job.SignalResponse, error) {
+	if err := s.checkAccess(c, in.GetUuid()); err != nil {
+		return nil, err
+	}
+	sig, err := worker.ParseSignal(in.GetSignal())
+	if err != nil {
+		return nil, statusError(err, "error signaling job")
+	}
+	if err := s.Worker.Signal(in.GetUuid(), sig); err != nil {
+		return nil, statusError(err, "error signaling job")
+	}
+	return &job.SignalResponse{}, nil
+}
+
+// Pause takes a UUID and freezes the job until it is resumed. Queued or exited jobs return
+// FAILED_PRECONDITION.
+//
+// Roles: [admin]
+func (s *jobManagerServer) Pause(c context.Context, in *job.PauseRequest) (*job.PauseResponse, error) {
+	if err := s.checkAccess(c, in.GetUuid()); err != nil {
+		return nil, err
+	}
+	if err := s.Worker.Pause(in.GetUuid()); err != nil {
+		return nil, statusError(err, "error pausing job")
+	}
+	return &job.PauseResponse{}, nil
+}
+
+// Resume takes a UUID and thaws a paused job
+//
+// Roles: [admin]
+func (s *jobManagerServer) Resume(c context.Context, in *job.ResumeRequest) (*job.ResumeResponse, error) {
+	if err := s.checkAccess(c, in.GetUuid()); err != nil {
+		return nil, err
+	}
+	if err := s.Worker.Resume(in.GetUuid()); err != nil {
+		return nil, statusError(err, "error resuming job")
+	}
+	return &job.ResumeResponse{}, nil
+}
+
+// Stop takes a UUID and stops the job, if it is still running. Jobs that already exited return
+// FAILED_PRECONDITION.
 //
 // Roles: [admin]
 func (s *jobManagerServer) Stop(c context.Context, in *job.StopRequest) (*job.StopResponse, error) {
-	if err := s.Worker.Stop(in.GetUuid()); err != nil {
+	if err := s.checkAccess(c, in.GetUuid()); err != nil {
 		return nil, err
 	}
+	if err := s.Worker.Stop(in.GetUuid()); err != nil {
+		return nil, statusError(err, "error stopping job")
+	}
 	return &job.StopResponse{}, nil
 }
 
 // Status takes a UUID and gets the status of the job
-// If successful, it returns the state of the job (RUNNING, STOPPED, ZOMBIE) or EXITED if the job is done
+// If successful, it returns the state of the job (QUEUED, RUNNING, PAUSED, STOPPED, ZOMBIE) or EXITED if the job is done
 //
 // Roles: [admin, user]
 func (s *jobManagerServer) Status(c context.Context, in *job.StatusRequest) (*job.StatusResponse, error) {
+	if err := s.checkAccess(c, in.GetUuid()); err != nil {
+		return nil, err
+	}
 	res, err := s.Worker.Status(in.GetUuid())
 	if err != nil {
-		return nil, fmt.Errorf("error getting process status: %v", err)
+		return nil, statusError(err, "error getting process status")
+	}
+	status := &job.StatusResponse{
+		Status:          res.State,
+		Terminated:      res.Terminated,
+		ExitCode:        int32(res.ExitCode),
+		OutputSize:      res.OutputSize,
+		ArchiveStatus:   res.ArchiveStatus,
+		ArchiveLocation: res.ArchiveLocation,
+		ArchiveError:    res.ArchiveError,
 	}
-	return &job.StatusResponse{Status: res.State, Terminated: res.Terminated, ExitCode: int32(res.ExitCode)}, nil
+	if !res.StartedAt.IsZero() {
+		status.StartedAt = timestamppb.New(res.StartedAt)
+		status.Duration = durationpb.New(res.Duration)
+		status.Usage = &job.ResourceUsage{
+			CpuTime:         durationpb.New(res.Usage.CPUTime),
+			PeakMemoryBytes: res.Usage.PeakMemory,
+			IoReadBytes:     res.Usage.IOReadBytes,
+			IoWriteBytes:    res.Usage.IOWriteBytes,
+		}
+	}
+	if !res.FinishedAt.IsZero() {
+		status.FinishedAt = timestamppb.New(res.FinishedAt)
+	}
+	return status, nil
 }
 
 // Output takes a UUID and streams the output of the job through a dataStream channel
 //
 // Roles: [admin, user]
 func (s *jobManagerServer) Output(in *job.OutputRequest, stream job.JobManager_OutputServer) error {
+	if err := s.checkAccess(stream.Context(), in.GetUuid()); err != nil {
+		return err
+	}
 	dataStream, err := s.Worker.Output(stream.Context(), in.GetUuid())
 	if err != nil {
-		return fmt.Errorf("error getting data stream: %v", err)
+		return statusError(err, "error getting data stream")
 	}
 	for {
 		select {
 		// if the context is cancelled, close the channel
 		case <-stream.Context().Done():
 			log.Print("stream context cancelled")
-			return stream.Context().Err()
+			return statusError(stream.Context().Err(), "error streaming output")
 		// read data off the stream (up to the chunk size set in the Worker library)
 		case data, ok := <-dataStream:
 			if !ok {
 				return nil
 			}
 			if err := stream.Send(&job.OutputResponse{Output: data}); err != nil {
-				return fmt.Errorf("error sending data from stream: %v", err)
+				return statusError(err, "error sending data from stream")
 			}
 		}
 	}
 }
+
+// maxGetOutputLimit caps the chunk size returned by GetOutput, well below the default 4MB
+// maximum message size of gRPC
+const maxGetOutputLimit = 1024 * 1024
+
+// GetOutput takes a UUID, an offset and a limit and returns up to limit bytes of the job's output
+// from offset, with the offset of the next chunk. It is an alternative to the Output stream for
+// clients that can't keep a stream open (e.g., behind proxies); eof is set once the job has
+// finished and all of its output has been read.
+//
+// Roles: [admin, user]
+func (s *jobManagerServer) GetOutput(c context.Context, in *job.GetOutputRequest) (*job.GetOutputResponse, error) {
+	if in.GetOffset() < 0 || in.GetLimit() < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "offset and limit must not be negative")
+	}
+	limit := int(in.GetLimit())
+	if limit > maxGetOutputLimit {
+		limit = maxGetOutputLimit
+	}
+	if err := s.checkAccess(c, in.GetUuid()); err != nil {
+		return nil, err
+	}
+	data, next, eof, err := s.Worker.ReadOutput(in.GetUuid(), in.GetOffset(), limit)
+	if err != nil {
+		return nil, statusError(err, "error reading output")
+	}
+	return &job.GetOutputResponse{Output: data, NextOffset: next, Eof: eof}, nil
+}
+
+// GetJob takes a UUID and returns how the job was configured, including the cgroup parameters
+// that were written for it (sorted by controller and file)
+//
+// Roles: [admin, user]
+func (s *jobManagerServer) GetJob(c context.Context, in *job.GetJobRequest) (*job.GetJobResponse, error) {
+	res, err := s.Worker.Describe(in.GetUuid())
+	if err != nil {
+		return nil, statusError(err, "error describing job")
+	}
+	if id, _ := identityFromContext(c); !id.canAccess(res.Owner) {
+		return nil, status.Errorf(codes.NotFound, "no job with uuid %s", in.GetUuid())
+	}
+	var cgroups []*job.CgroupParam
+	for controller, params := range res.Cgroups {
+		for file, value := range params {
+			cgroups = append(cgroups, &job.CgroupParam{Controller: controller, File: file, Value: value})
+		}
+	}
+	sort.Slice(cgroups, func(i, j int) bool {
+		if cgroups[i].Controller != cgroups[j].Controller {
+			return cgroups[i].Controller < cgroups[j].Controller
+		}
+		return cgroups[i].File < cgroups[j].File
+	})
+	// only return the names of environment variables, since their values may be secrets
+	var envNames []string
+	for _, kv := range res.Env {
+		name, _, _ := strings.Cut(kv, "=")
+		envNames = append(envNames, name)
+	}
+	return &job.GetJobResponse{
+		Uuid:       res.UUID,
+		Cmd:        res.Name,
+		Args:       res.Args,
+		Cgroups:    cgroups,
+		Cwd:        res.Dir,
+		EnvNames:   envNames,
+		Owner:      res.Owner,
+		ParentUuid: res.Parent,
+	}, nil
+}
+
+// List returns a summary of all jobs on the worker the client can access (all for admins, the
+// jobs they started for other roles), most recently created first
+//
+// Roles: [admin, user]
+func (s *jobManagerServer) List(c context.Context, in *job.ListRequest) (*job.ListResponse, error) {
+	id, _ := identityFromContext(c)
+	var jobs []*job.JobSummary
+	for _, info := range s.Worker.List() {
+		if !id.canAccess(info.Owner) {
+			continue
+		}
+		jobs = append(jobs, &job.JobSummary{
+			Uuid:       info.UUID,
+			Cmd:        info.Name,
+			Args:       info.Args,
+			CreatedAt:  timestamppb.New(info.Created),
+			Owner:      info.Owner,
+			ParentUuid: info.Parent,
+		})
+	}
+	return &job.ListResponse{Jobs: jobs}, nil
+}
+
+// checkAccess returns NOT_FOUND if the client in the context can't access a job (see
+// identity.canAccess), so clients can't tell the jobs of others from jobs that don't exist
+func (s *jobManagerServer) checkAccess(c context.Context, uuid string) error {
+	info, err := s.Worker.Describe(uuid)
+	if err != nil {
+		return statusError(err, "error getting job")
+	}
+	if id, _ := identityFromContext(c); !id.canAccess(info.Owner) {
+		return status.Errorf(codes.NotFound, "no job with uuid %s", uuid)
+	}
+	return nil
+}
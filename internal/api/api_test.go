@@ -2,17 +2,35 @@ package api
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
 	"errors"
 	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/rorski/grpc-job-manager/internal/job"
 	"github.com/rorski/grpc-job-manager/worker"
 	"github.com/stretchr/testify/assert"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
 )
 
 var conf = Config{
@@ -38,6 +56,10 @@ func TestUserHasStatusAndOutputAuth(t *testing.T) {
 func TestUserNotHaveStartAndStopAuth(t *testing.T) {
 	assert.False(t, isAuthorized("/job.JobManager/Start", "user"))
 	assert.False(t, isAuthorized("/job.JobManager/Stop", "user"))
+	assert.False(t, isAuthorized("/job.JobManager/Signal", "user"))
+	assert.False(t, isAuthorized("/job.JobManager/Restart", "user"))
+	assert.False(t, isAuthorized("/job.JobManager/Pause", "user"))
+	assert.False(t, isAuthorized("/job.JobManager/Resume", "user"))
 }
 
 // TestAuthzStartAsAdmin tests starting a "ps" job with an admin role (from the client cert)
@@ -46,7 +68,7 @@ func TestAuthzStartAsAdmin(t *testing.T) {
 	serverCreds, err := loadServerCreds()
 	assert.NoError(t, err)
 
-	s, lis, err := newGrpcServer(conf, serverCreds)
+	s, lis, err := newGrpcServer(conf, serverCreds, nil, nil, nil)
 	defer s.Stop()
 	job.RegisterJobManagerServer(s, &jobManagerServer{Worker: *worker.New()})
 	go func() {
@@ -74,7 +96,7 @@ func TestAuthzStartAsUser(t *testing.T) {
 	serverCreds, err := loadServerCreds()
 	assert.NoError(t, err)
 
-	s, lis, err := newGrpcServer(conf, serverCreds)
+	s, lis, err := newGrpcServer(conf, serverCreds, nil, nil, nil)
 	defer s.Stop()
 	job.RegisterJobManagerServer(s, &jobManagerServer{Worker: *worker.New()})
 	go func() {
@@ -94,9 +116,260 @@ func TestAuthzStartAsUser(t *testing.T) {
 	jobClient := job.NewJobManagerClient(conn)
 	res, err := jobClient.Start(context.Background(), &job.StartRequest{Cmd: "ps"})
 	assert.NotNil(t, err)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
 	assert.Nil(t, res)
 }
 
+// TestStatusCodes checks the gRPC status codes returned for bad requests and worker errors
+func TestStatusCodes(t *testing.T) {
+	srv := &jobManagerServer{Worker: *worker.New()}
+	admin := contextWithIdentity(context.Background(), identity{CN: "client_admin", Role: "admin"})
+
+	_, err := srv.Status(admin, &job.StatusRequest{Uuid: "d7fe4474-2eb0-4dea-94a6-dacbdc75bf4f"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = srv.Start(admin, &job.StartRequest{Cmd: "ps", Cwd: "relative/dir"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	res, err := srv.Start(admin, &job.StartRequest{Cmd: "ps"})
+	assert.NoError(t, err)
+	_, err = srv.GetOutput(admin, &job.GetOutputRequest{Uuid: res.GetUuid(), Offset: -1})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = authorize(context.Background(), "/job.JobManager/Start")
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	assert.Equal(t, codes.FailedPrecondition, status.Code(statusError(worker.ErrJobAlreadyExited, "error stopping job")))
+	assert.Equal(t, codes.Internal, status.Code(statusError(errors.New("boom"), "error starting job")))
+}
+
+// TestJobsScopedToOwner checks that users can only see the jobs they started, and admins all jobs
+func TestJobsScopedToOwner(t *testing.T) {
+	srv := &jobManagerServer{Worker: *worker.New()}
+	admin := contextWithIdentity(context.Background(), identity{CN: "client_admin", Role: "admin"})
+	user := contextWithIdentity(context.Background(), identity{CN: "client_user", Role: "user"})
+
+	adminJob, err := srv.Start(admin, &job.StartRequest{Cmd: "ps"})
+	assert.NoError(t, err)
+	userJob, err := srv.Start(user, &job.StartRequest{Cmd: "ps"})
+	assert.NoError(t, err)
+
+	_, err = srv.Status(user, &job.StatusRequest{Uuid: adminJob.GetUuid()})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = srv.GetJob(user, &job.GetJobRequest{Uuid: adminJob.GetUuid()})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = srv.Stop(user, &job.StopRequest{Uuid: adminJob.GetUuid()})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = srv.Status(user, &job.StatusRequest{Uuid: userJob.GetUuid()})
+	assert.NoError(t, err)
+	_, err = srv.Status(admin, &job.StatusRequest{Uuid: userJob.GetUuid()})
+	assert.NoError(t, err)
+
+	res, err := srv.List(user, &job.ListRequest{})
+	assert.NoError(t, err)
+	assert.Len(t, res.GetJobs(), 1)
+	assert.Equal(t, "client_user", res.GetJobs()[0].GetOwner())
+	res, err = srv.List(admin, &job.ListRequest{})
+	assert.NoError(t, err)
+	assert.Len(t, res.GetJobs(), 2)
+}
+
+// TestStartFromTemplate starts a job from a template as a user, with its params filled in
+func TestStartFromTemplate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "templates.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`{"templates": [{"name": "list", "cmd": "ls", "args": ["-l", "/tmp/${dir}"],
+		"params": {"dir": "[a-z]+"}, "cgroups": {"memory": {"memory.limit_in_bytes": "64M"}}}]}`), 0600))
+	templates, err := loadTemplates(path)
+	assert.NoError(t, err)
+	srv := &jobManagerServer{Worker: *worker.New(), templates: templates}
+	user := contextWithIdentity(context.Background(), identity{CN: "client_user", Role: "user"})
+
+	for _, req := range []*job.StartFromTemplateRequest{
+		{Template: "list"}, // missing param
+		{Template: "list", Params: map[string]string{"dir": "../etc"}},                // doesn't match the pattern
+		{Template: "list", Params: map[string]string{"dir": "logs", "extra": "true"}}, // unknown param
+	} {
+		_, err := srv.StartFromTemplate(user, req)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	}
+	_, err = srv.StartFromTemplate(user, &job.StartFromTemplateRequest{Template: "rm"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	res, err := srv.StartFromTemplate(user, &job.StartFromTemplateRequest{Template: "list", Params: map[string]string{"dir": "logs"}})
+	assert.NoError(t, err)
+	info, err := srv.GetJob(user, &job.GetJobRequest{Uuid: res.GetUuid()})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"-l", "/tmp/logs"}, info.GetArgs())
+	assert.Equal(t, "client_user", info.GetOwner())
+
+	// placeholders without a param are rejected when loading templates
+	assert.NoError(t, os.WriteFile(path, []byte(`{"templates": [{"name": "echo", "cmd": "echo", "args": ["${msg}"]}]}`), 0600))
+	_, err = loadTemplates(path)
+	assert.Error(t, err)
+}
+
+// TestAuditLog records a rejected Start call from a user certificate in the audit log and
+// checks the identity, request and result of the entry
+func TestAuditLog(t *testing.T) {
+	audit, err := newAuditLog(filepath.Join(t.TempDir(), "audit.log"))
+	assert.NoError(t, err)
+	defer audit.Close()
+
+	// add the user certificate to the context as the peer
+	block, _ := pem.Decode(clientUserCert)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	assert.NoError(t, err)
+	ctx := peer.NewContext(context.Background(), &peer.Peer{
+		AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}},
+	})
+
+	// chain the audit and authorization interceptors like the server does
+	info := &grpc.UnaryServerInfo{FullMethod: "/job.JobManager/Start"}
+	handler := func(ctx context.Context, req any) (any, error) {
+		return unaryInterceptor(ctx, req, info, func(context.Context, any) (any, error) {
+			return &job.StartResponse{}, nil
+		})
+	}
+	_, err = audit.unaryInterceptor(ctx, &job.StartRequest{Cmd: "ps", Args: []string{"aux"}}, info, handler)
+	assert.Error(t, err)
+
+	data, err := os.ReadFile(audit.file.Name())
+	assert.NoError(t, err)
+	var entry auditEntry
+	assert.NoError(t, json.Unmarshal(data, &entry))
+	assert.Equal(t, "/job.JobManager/Start", entry.Method)
+	assert.Equal(t, "client_user", entry.CN)
+	assert.Equal(t, "user", entry.Role)
+	assert.JSONEq(t, `{"cmd": "ps", "args": ["aux"]}`, string(entry.Request))
+	assert.Equal(t, "PermissionDenied", entry.Code)
+	assert.NotEmpty(t, entry.Error)
+}
+
+// TestCRLRevokedCertificate revokes a client certificate with a CRL signed by a test CA and checks
+// it is rejected at handshake and call time, while other certificates are still accepted
+func TestCRLRevokedCertificate(t *testing.T) {
+	dir := t.TempDir()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test_ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	caDer, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	assert.NoError(t, err)
+	ca, err := x509.ParseCertificate(caDer)
+	assert.NoError(t, err)
+	caFile := filepath.Join(dir, "ca.pem")
+	assert.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDer}), 0600))
+
+	// revoke the certificate with serial 2
+	crlDer, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:              big.NewInt(1),
+		ThisUpdate:          time.Now(),
+		NextUpdate:          time.Now().Add(time.Hour),
+		RevokedCertificates: []pkix.RevokedCertificate{{SerialNumber: big.NewInt(2), RevocationTime: time.Now()}},
+	}, ca, caKey)
+	assert.NoError(t, err)
+	crlFile := filepath.Join(dir, "crl.pem")
+	assert.NoError(t, os.WriteFile(crlFile, pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: crlDer}), 0600))
+
+	crl, err := newCRLChecker(crlFile, caFile)
+	assert.NoError(t, err)
+
+	revoked := &x509.Certificate{SerialNumber: big.NewInt(2), Subject: pkix.Name{CommonName: "client_admin"}}
+	valid := &x509.Certificate{SerialNumber: big.NewInt(3), Subject: pkix.Name{CommonName: "client_user"}}
+	assert.Error(t, crl.verifyPeerCertificate(nil, [][]*x509.Certificate{{revoked, ca}}))
+	assert.NoError(t, crl.verifyPeerCertificate(nil, [][]*x509.Certificate{{valid, ca}}))
+
+	peerContext := func(cert *x509.Certificate) context.Context {
+		return peer.NewContext(context.Background(), &peer.Peer{
+			AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}},
+		})
+	}
+	assert.Error(t, crl.checkPeer(peerContext(revoked)))
+	assert.NoError(t, crl.checkPeer(peerContext(valid)))
+
+	// a CRL that is not signed by the CA is refused
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	otherDer, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{Number: big.NewInt(2)}, ca, otherKey)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(crlFile, otherDer, 0600))
+	assert.Error(t, crl.load())
+	assert.True(t, crl.isRevoked(revoked), "previous CRL should stay in effect")
+}
+
+// TestTokenAuthentication verifies ES256 tokens against a JWKS discovered from the issuer
+func TestTokenAuthentication(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	mux := http.NewServeMux()
+	issuer := httptest.NewServer(mux)
+	defer issuer.Close()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"issuer": %q, "jwks_uri": %q}`, issuer.URL, issuer.URL+"/jwks")
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"keys": [{"kty": "EC", "kid": "k1", "use": "sig", "crv": "P-256", "x": %q, "y": %q}]}`,
+			base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+			base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))))
+	})
+
+	tokens, err := newTokenVerifier(Config{TokenIssuer: issuer.URL, TokenAudience: "jobmanager"})
+	assert.NoError(t, err)
+
+	sign := func(alg string, claims map[string]any) string {
+		header, _ := json.Marshal(map[string]string{"alg": alg, "kid": "k1"})
+		payload, _ := json.Marshal(claims)
+		signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+		digest := sha256.Sum256([]byte(signed))
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		assert.NoError(t, err)
+		signature := append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+		return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+	}
+	claims := func(overrides map[string]any) map[string]any {
+		c := map[string]any{"iss": issuer.URL, "aud": []string{"jobmanager"}, "sub": "alice", "role": "admin", "exp": time.Now().Add(time.Hour).Unix()}
+		for k, v := range overrides {
+			c[k] = v
+		}
+		return c
+	}
+
+	id, err := tokens.verify(sign("ES256", claims(nil)))
+	assert.NoError(t, err)
+	assert.Equal(t, identity{CN: "alice", Role: "admin"}, id)
+	id, err = tokens.verify(sign("ES256", claims(map[string]any{"role": []string{"viewer", "user"}})))
+	assert.NoError(t, err)
+	assert.Equal(t, "user", id.Role)
+
+	for _, token := range []string{
+		sign("ES256", claims(map[string]any{"exp": time.Now().Add(-time.Hour).Unix()})), // expired
+		sign("ES256", claims(map[string]any{"iss": "https://other"})),                   // wrong issuer
+		sign("ES256", claims(map[string]any{"aud": "other"})),                           // wrong audience
+		sign("ES256", claims(map[string]any{"role": nil})),                              // no role
+		sign("HS256", claims(nil)),                                                      // symmetric algorithm
+		sign("ES256", claims(nil))[:20] + "x" + sign("ES256", claims(nil))[21:],         // tampered
+		"not.a.token",
+	} {
+		_, err := tokens.verify(token)
+		assert.Error(t, err, token)
+	}
+
+	// the identity from the token in the call metadata is used for authorization
+	md := metadata.Pairs("authorization", "Bearer "+sign("ES256", claims(map[string]any{"role": "user"})))
+	ctx := tokens.authenticate(metadata.NewIncomingContext(context.Background(), md))
+	_, err = authorize(ctx, "/job.JobManager/Start")
+	assert.Error(t, err)
+	id, err = authorize(ctx, "/job.JobManager/Status")
+	assert.NoError(t, err)
+	assert.Equal(t, "alice", id.CN)
+	_, err = authorize(tokens.authenticate(context.Background()), "/job.JobManager/Status")
+	assert.Error(t, err)
+}
+
 func loadClientCreds(ca []byte, role string) (credentials.TransportCredentials, error) {
 	certPool := x509.NewCertPool()
 	if !certPool.AppendCertsFromPEM(caCert) {
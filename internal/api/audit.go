@@ -0,0 +1,112 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// auditLog records every RPC to an append-only file as JSON lines, including the identity of
+// the client (from its certificate), the request parameters and the result of the call
+type auditLog struct {
+	mu   sync.Mutex // serializes writes so entries are never interleaved
+	file *os.File
+}
+
+// auditEntry is a single line in the audit log
+type auditEntry struct {
+	Time    time.Time       `json:"time"`
+	Method  string          `json:"method"`
+	CN      string          `json:"cn"`
+	Role    string          `json:"role"`
+	Request json.RawMessage `json:"request,omitempty"`
+	Code    string          `json:"code"`
+	Error   string          `json:"error,omitempty"`
+}
+
+// newAuditLog opens (or creates) the audit log at path for appending
+func newAuditLog(path string) (*auditLog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("error opening audit log %s: %v", path, err)
+	}
+	return &auditLog{file: f}, nil
+}
+
+func (a *auditLog) Close() error {
+	return a.file.Close()
+}
+
+// record writes an entry for a call to method, started at start, with the request req and the
+// error returned by the handler (nil if the call succeeded)
+func (a *auditLog) record(ctx context.Context, method string, start time.Time, req any, err error) {
+	// the identity is recorded even if the call is rejected, as long as the client was authenticated
+	id, _ := clientIdentity(ctx)
+	entry := auditEntry{
+		Time:   start,
+		Method: method,
+		CN:     id.CN,
+		Role:   id.Role,
+		Code:   status.Code(err).String(),
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	if msg, ok := req.(proto.Message); ok {
+		if request, err := protojson.Marshal(msg); err == nil {
+			entry.Request = request
+		}
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("error encoding audit entry: %v", err)
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.file.Write(append(line, '\n')); err != nil {
+		log.Printf("error writing audit entry: %v", err)
+	}
+}
+
+// unaryInterceptor records unary calls in the audit log. It runs before the authorization
+// interceptor so rejected calls are recorded too.
+func (a *auditLog) unaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	res, err := handler(ctx, req)
+	a.record(ctx, info.FullMethod, start, req, err)
+	return res, err
+}
+
+// streamInterceptor records streaming calls in the audit log once the stream is finished
+func (a *auditLog) streamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	stream := &auditStream{ServerStream: ss}
+	err := handler(srv, stream)
+	a.record(ss.Context(), info.FullMethod, start, stream.req, err)
+	return err
+}
+
+// auditStream captures the request message of a server stream so it can be audited
+type auditStream struct {
+	grpc.ServerStream
+	req any
+}
+
+func (s *auditStream) RecvMsg(m any) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil && s.req == nil {
+		s.req = m
+	}
+	return err
+}
@@ -3,48 +3,147 @@ package api
 import (
 	"context"
 	"errors"
-	"fmt"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
 )
 
 // roleMap defines the accessible methods for each role
 var roleMap = map[string][]string{
-	"/job.JobManager/Start":  {"admin"},
-	"/job.JobManager/Stop":   {"admin"},
-	"/job.JobManager/Status": {"admin", "user"},
-	"/job.JobManager/Output": {"admin", "user"},
+	"/job.JobManager/Start":             {"admin"},
+	"/job.JobManager/StartFromTemplate": {"admin", "user"}, // templates are curated by admins
+	"/job.JobManager/Restart":           {"admin"},
+	"/job.JobManager/Stop":              {"admin"},
+	"/job.JobManager/Signal":            {"admin"},
+	"/job.JobManager/Pause":             {"admin"},
+	"/job.JobManager/Resume":            {"admin"},
+	"/job.JobManager/Status":            {"admin", "user"},
+	"/job.JobManager/Output":            {"admin", "user"},
+	"/job.JobManager/GetOutput":         {"admin", "user"},
+	"/job.JobManager/GetJob":            {"admin", "user"},
+	"/job.JobManager/List":              {"admin", "user"},
+	// standard gRPC health checking service
+	"/grpc.health.v1.Health/Check": {"admin", "user"},
+	"/grpc.health.v1.Health/Watch": {"admin", "user"},
 }
 
 // unaryInterceptor is a grpc inteceptor that authorizes access to the methods as listed in roleMap
+// and passes the identity of the client to the handler in its context
 func unaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	id, err := authorize(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(contextWithIdentity(ctx, id), req)
+}
+
+// streamInterceptor is a grpc inteceptor that authorizes access to the streaming methods
+// (e.g., Output) as listed in roleMap and passes the identity of the client to the handler in
+// the stream context
+func streamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	id, err := authorize(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &contextStream{ServerStream: ss, ctx: contextWithIdentity(ss.Context(), id)})
+}
+
+// contextStream is a grpc.ServerStream with a context replaced by an interceptor (e.g., to add
+// the identity of the client)
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
+
+// authorize checks that the role in the client certificate has access to a method and
+// returns the identity of the client. It returns UNAUTHENTICATED if the client has no valid
+// identity, and PERMISSION_DENIED if its role can't call the method.
+func authorize(ctx context.Context, method string) (identity, error) {
+	id, err := clientIdentity(ctx)
+	if err != nil {
+		return identity{}, status.Errorf(codes.Unauthenticated, "%v", err)
+	}
+	if !isAuthorized(method, id.Role) {
+		return identity{}, status.Errorf(codes.PermissionDenied, "role %q is not authorized to execute %s", id.Role, method)
+	}
+	return id, nil
+}
+
+// authResult is the result of authenticating a call with a bearer token (see tokenVerifier)
+type authResult struct {
+	id  identity
+	err error
+}
+
+type authKey struct{}
+
+// clientIdentity returns the identity of the client of a call: from its bearer token if the
+// server uses token authentication, otherwise from its client certificate
+func clientIdentity(ctx context.Context) (identity, error) {
+	if auth, ok := ctx.Value(authKey{}).(authResult); ok {
+		return auth.id, auth.err
+	}
+	cn, role, err := peerIdentity(ctx)
+	if err != nil {
+		return identity{}, err
+	}
+	return identity{CN: cn, Role: role}, nil
+}
+
+// identity is the authenticated client of a call
+type identity struct {
+	CN   string // common name of the client certificate (or identity claim of its token), which owns the jobs started by the client
+	Role string // role of the client (admin or user)
+}
+
+// canAccess reports whether the client can see and act on a job owned by owner: admins can
+// access all jobs, other roles only the jobs they started
+func (id identity) canAccess(owner string) bool {
+	return id.Role == "admin" || id.CN == owner
+}
+
+type identityKey struct{}
+
+func contextWithIdentity(ctx context.Context, id identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// identityFromContext returns the identity added to a handler context by the authorization
+// interceptors
+func identityFromContext(ctx context.Context) (identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(identity)
+	return id, ok
+}
+
+// peerIdentity returns the common name and role of the client certificate in a context
+func peerIdentity(ctx context.Context) (cn, role string, err error) {
 	// get the peer information so we can parse the client certificate out of it
 	peer, ok := peer.FromContext(ctx)
 	if !ok {
-		return nil, errors.New("error reading peer information from context")
+		return "", "", errors.New("error reading peer information from context")
 	}
 	tlsInfo, ok := peer.AuthInfo.(credentials.TLSInfo)
 	if !ok {
-		return nil, errors.New("could not find peer authentication information")
+		return "", "", errors.New("could not find peer authentication information")
 	}
 	// get the peer (client) certificate from tlsInfo
 	peerCerts := tlsInfo.State.PeerCertificates
 	if len(peerCerts) == 0 {
-		return nil, errors.New("missing peer certificate")
+		return "", "", errors.New("missing peer certificate")
 	} else if len(peerCerts[0].Subject.Organization) == 0 {
-		return nil, errors.New("no role set for certificate")
+		return "", "", errors.New("no role set for certificate")
 	}
 
-	// find role from client certificate and check if it has access to the method.
+	// find role from client certificate.
 	// I'm assuming just one role is set for simplicity, but in production this would support multiple roles
-	role := peerCerts[0].Subject.Organization[0]
-	if !isAuthorized(info.FullMethod, role) {
-		return nil, fmt.Errorf("role %q is not unauthorized to execute %s", role, info.FullMethod)
-	}
-
-	return handler(ctx, req)
+	return peerCerts[0].Subject.CommonName, peerCerts[0].Subject.Organization[0], nil
 }
 
 func isAuthorized(method, role string) bool {
@@ -0,0 +1,51 @@
+package api
+
+import (
+	"context"
+	"errors"
+
+	"github.com/rorski/grpc-job-manager/worker"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// statusError returns an error as a gRPC status, prefixed with a message saying what the method
+// was doing (e.g., "error stopping job"), so clients can branch on the code:
+//   - NOT_FOUND for unknown jobs or missing output
+//   - FAILED_PRECONDITION for jobs that already exited (or are still queued, or have no freezer
+//     cgroup to be paused with)
+//   - INVALID_ARGUMENT for jobs rejected by the worker config and unknown signals
+//   - PERMISSION_DENIED for commands not allowed by the worker config
+//   - RESOURCE_EXHAUSTED when the worker's job limit is reached
+//   - UNAVAILABLE when the worker is draining
+//   - CANCELLED or DEADLINE_EXCEEDED when the call's context is done
+//   - INTERNAL for anything else
+//
+// Errors that already are a gRPC status are returned as is.
+func statusError(err error, msg string) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	code := codes.Internal
+	switch {
+	case errors.Is(err, worker.ErrJobNotFound), errors.Is(err, worker.ErrOutputMissing):
+		code = codes.NotFound
+	case errors.Is(err, worker.ErrJobAlreadyExited), errors.Is(err, worker.ErrJobQueued),
+		errors.Is(err, worker.ErrFreezerUnavailable):
+		code = codes.FailedPrecondition
+	case errors.Is(err, worker.ErrInvalidJob), errors.Is(err, worker.ErrInvalidSignal):
+		code = codes.InvalidArgument
+	case errors.Is(err, worker.ErrCommandDenied):
+		code = codes.PermissionDenied
+	case errors.Is(err, worker.ErrJobLimitReached):
+		code = codes.ResourceExhausted
+	case errors.Is(err, worker.ErrDraining):
+		code = codes.Unavailable
+	case errors.Is(err, context.Canceled):
+		code = codes.Canceled
+	case errors.Is(err, context.DeadlineExceeded):
+		code = codes.DeadlineExceeded
+	}
+	return status.Errorf(code, "%s: %v", msg, err)
+}
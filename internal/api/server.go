@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
@@ -9,26 +10,63 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/rorski/grpc-job-manager/internal/job"
 	"github.com/rorski/grpc-job-manager/worker"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
 // Config holds information for setting up a gRPC server (host, port and certificates)
+// and the worker it runs jobs on (job limits)
 type Config struct {
 	Host                 string
 	Port                 int
 	Certificate, Key, CA string
+	MaxJobs              int           // maximum number of concurrently running jobs (0 for no limit)
+	QueueJobs            bool          // queue jobs over MaxJobs instead of rejecting them
+	SyncOutput           bool          // flush job output to disk before reporting jobs as exited
+	AuditLog             string        // path to an append-only audit log of all RPCs (disabled if empty)
+	CRL                  string        // path to a CRL of revoked client certificates (disabled if empty)
+	CRLReload            time.Duration // how often the CRL is reloaded (never if 0)
+	DrainTimeout         time.Duration // how long to wait for running jobs on shutdown before stopping them
+	DrainState           string        // path to save job records to on shutdown (disabled if empty)
+	OutputWatcher        string        // how job output is followed: auto, inotify or poll (see worker.NewFileWatcher)
+	EnvDeny, EnvAllow    []string      // patterns of environment variables jobs can't set, or only can set (if not empty)
+	ArchiveURL           string        // sink to archive the output of every job to (see worker.NewArchiver, disabled if empty)
+	ArchiveAllow         []string      // URL prefixes of the archive sinks jobs can choose themselves
+	// patterns of the absolute paths of commands jobs can't run, or only can run (if not empty)
+	CommandDeny, CommandAllow []string
+	// Auth is how clients authenticate: "mtls" (the default) with client certificates, or "jwt"
+	// with a bearer token verified against the keys of TokenIssuer (or TokenJWKS)
+	Auth                                  string
+	TokenIssuer, TokenJWKS, TokenAudience string
+	TokenIdentityClaim, TokenRoleClaim    string // claims with the identity and role of a client ("sub" and "role" by default)
+	Templates                             string // path to a JSON file of job templates clients can start by name (none if empty)
 }
 
-func setupCreds(certFile, keyFile, caFile string) (credentials.TransportCredentials, error) {
+// stopGracePeriod is how long to wait for in-flight calls (e.g., an Output stream following a
+// job) to finish on shutdown before closing their connections
+const stopGracePeriod = 5 * time.Second
+
+// setupCreds creates the server TLS credentials. If crl is not nil, revoked client certificates
+// are rejected during the handshake. Without mTLS (i.e., clients authenticate with tokens),
+// client certificates are not requested and caFile is not used.
+func setupCreds(certFile, keyFile, caFile string, mTLS bool, crl *crlChecker) (credentials.TransportCredentials, error) {
 	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load x509 key pair: %v", err)
 	}
+	if !mTLS {
+		return credentials.NewTLS(&tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS13,
+		}), nil
+	}
 	caPem, err := os.ReadFile(caFile)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read CA pem: %v", err)
@@ -38,23 +76,47 @@ func setupCreds(certFile, keyFile, caFile string) (credentials.TransportCredenti
 		return nil, fmt.Errorf("failed to add CA cert to pool: %v", err)
 	}
 
-	return credentials.NewTLS(&tls.Config{
+	tlsConfig := &tls.Config{
 		Certificates: []tls.Certificate{cert},
 		ClientAuth:   tls.RequireAndVerifyClientCert, // require client auth (i.e., mTLS)
 		ClientCAs:    certPool,
 		MinVersion:   tls.VersionTLS13,
-	}), nil
+	}
+	if crl != nil {
+		tlsConfig.VerifyPeerCertificate = crl.verifyPeerCertificate
+	}
+	return credentials.NewTLS(tlsConfig), nil
 }
 
-func newGrpcServer(conf Config, creds credentials.TransportCredentials) (*grpc.Server, net.Listener, error) {
+// newGrpcServer creates a gRPC server and listener. If audit is not nil, every call is recorded
+// in the audit log, including calls that are not authorized. If crl is not nil, calls from
+// revoked certificates are rejected before authorization. If tokens is not nil, clients are
+// authenticated with bearer tokens instead of their certificates.
+func newGrpcServer(conf Config, creds credentials.TransportCredentials, audit *auditLog, crl *crlChecker, tokens *tokenVerifier) (*grpc.Server, net.Listener, error) {
 	address := fmt.Sprintf("%s:%d", conf.Host, conf.Port)
 	listener, err := net.Listen("tcp", address)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to listen on %s: %v", address, err)
 	}
+	// interceptors to verify client access to methods
+	unaryInterceptors := []grpc.UnaryServerInterceptor{unaryInterceptor}
+	streamInterceptors := []grpc.StreamServerInterceptor{streamInterceptor}
+	if crl != nil {
+		unaryInterceptors = append([]grpc.UnaryServerInterceptor{crl.unaryInterceptor}, unaryInterceptors...)
+		streamInterceptors = append([]grpc.StreamServerInterceptor{crl.streamInterceptor}, streamInterceptors...)
+	}
+	if audit != nil {
+		unaryInterceptors = append([]grpc.UnaryServerInterceptor{audit.unaryInterceptor}, unaryInterceptors...)
+		streamInterceptors = append([]grpc.StreamServerInterceptor{audit.streamInterceptor}, streamInterceptors...)
+	}
+	if tokens != nil {
+		unaryInterceptors = append([]grpc.UnaryServerInterceptor{tokens.unaryInterceptor}, unaryInterceptors...)
+		streamInterceptors = append([]grpc.StreamServerInterceptor{tokens.streamInterceptor}, streamInterceptors...)
+	}
 	server := grpc.NewServer(
 		grpc.Creds(creds),
-		grpc.UnaryInterceptor(unaryInterceptor), // unary interceptor to verify client access to methods
+		grpc.ChainUnaryInterceptor(unaryInterceptors...),
+		grpc.ChainStreamInterceptor(streamInterceptors...),
 	)
 
 	return server, listener, nil
@@ -62,30 +124,143 @@ func newGrpcServer(conf Config, creds credentials.TransportCredentials) (*grpc.S
 
 // Serve creates a new gRPC server from a Config
 func Serve(conf Config) error {
-	creds, err := setupCreds(conf.Certificate, conf.Key, conf.CA)
+	var tokens *tokenVerifier
+	switch conf.Auth {
+	case "", "mtls":
+	case "jwt":
+		if conf.CRL != "" {
+			return fmt.Errorf("a CRL can only be used with mTLS authentication")
+		}
+		v, err := newTokenVerifier(conf)
+		if err != nil {
+			return fmt.Errorf("error setting up token authentication: %v", err)
+		}
+		tokens = v
+	default:
+		return fmt.Errorf("unknown authentication %q (expected mtls or jwt)", conf.Auth)
+	}
+	var crl *crlChecker
+	if conf.CRL != "" {
+		c, err := newCRLChecker(conf.CRL, conf.CA)
+		if err != nil {
+			return fmt.Errorf("error loading CRL: %v", err)
+		}
+		crl = c
+		if conf.CRLReload > 0 {
+			stop := make(chan struct{})
+			defer close(stop)
+			go crl.reload(conf.CRLReload, stop)
+		}
+	}
+	creds, err := setupCreds(conf.Certificate, conf.Key, conf.CA, tokens == nil, crl)
 	if err != nil {
 		return fmt.Errorf("error setting up credentials: %v", err)
 	}
-	s, lis, err := newGrpcServer(conf, creds)
+	var audit *auditLog
+	if conf.AuditLog != "" {
+		if audit, err = newAuditLog(conf.AuditLog); err != nil {
+			return err
+		}
+		defer audit.Close()
+	}
+	s, lis, err := newGrpcServer(conf, creds, audit, crl, tokens)
 	if err != nil {
 		return fmt.Errorf("error creating new grpc server: %v", err)
 	}
 	defer lis.Close()
-	job.RegisterJobManagerServer(s, &jobManagerServer{Worker: *worker.New()})
-
-	// just using the standard "log" library. In production this would be something more robust like logrus or zap
-	log.Printf("server listening at %v", lis.Addr())
-	if err := s.Serve(lis); err != nil {
-		return fmt.Errorf("failed to start server: %v", err)
+	watcher, err := worker.NewFileWatcher(conf.OutputWatcher)
+	if err != nil {
+		return fmt.Errorf("error creating output watcher: %v", err)
 	}
+	var archiver worker.Archiver
+	if conf.ArchiveURL != "" {
+		if archiver, err = worker.NewArchiver(conf.ArchiveURL); err != nil {
+			return fmt.Errorf("error creating output archiver: %v", err)
+		}
+	}
+	srv := &jobManagerServer{Worker: *worker.New()}
+	if conf.Templates != "" {
+		if srv.templates, err = loadTemplates(conf.Templates); err != nil {
+			return fmt.Errorf("error loading job templates: %v", err)
+		}
+	}
+	srv.Worker.Config.MaxJobs = conf.MaxJobs
+	srv.Worker.Config.QueueJobs = conf.QueueJobs
+	srv.Worker.Config.SyncOutput = conf.SyncOutput
+	srv.Worker.Config.Watcher = watcher
+	srv.Worker.Config.EnvDeny = conf.EnvDeny
+	srv.Worker.Config.EnvAllow = conf.EnvAllow
+	srv.Worker.Config.Archiver = archiver
+	srv.Worker.Config.ArchiveAllow = conf.ArchiveAllow
+	srv.Worker.Config.CommandDeny = conf.CommandDeny
+	srv.Worker.Config.CommandAllow = conf.CommandAllow
+	job.RegisterJobManagerServer(s, srv)
+	// the health service reports NOT_SERVING once the server starts draining
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(s, healthServer)
 
-	// shutdown gracefully
+	// shutdown gracefully, draining jobs first
 	shutdown := make(chan os.Signal, 1)
 	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(shutdown)
+	shutdownDone := make(chan struct{})
 	go func() {
-		<-shutdown
-		s.GracefulStop()
+		defer close(shutdownDone)
+		sig := <-shutdown
+		log.Printf("received %v, draining", sig)
+		healthServer.Shutdown()
+		drain(&srv.Worker, conf)
+
+		stopped := make(chan struct{})
+		go func() {
+			s.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-time.After(stopGracePeriod):
+			log.Print("timed out waiting for calls to finish, stopping")
+			s.Stop()
+		}
 	}()
 
+	// just using the standard "log" library. In production this would be something more robust like logrus or zap
+	log.Printf("server listening at %v", lis.Addr())
+	if err := s.Serve(lis); err != nil {
+		return fmt.Errorf("failed to start server: %v", err)
+	}
+	// Serve returns as soon as the server starts stopping, so wait for the shutdown to finish
+	<-shutdownDone
+	log.Print("server stopped")
+
 	return nil
 }
+
+// drain stops the worker from accepting new jobs and waits up to conf.DrainTimeout for running
+// jobs to finish. Jobs still running after that are stopped, since they would otherwise be
+// terminated along with the server. With conf.DrainState, job records are then saved to a file.
+func drain(w *worker.Worker, conf Config) {
+	w.Drain()
+
+	ctx, cancel := context.WithTimeout(context.Background(), conf.DrainTimeout)
+	defer cancel()
+	if err := w.WaitAll(ctx); err != nil {
+		if n := w.StopAll(); n > 0 {
+			log.Printf("stopped %d jobs still running after %v", n, conf.DrainTimeout)
+		}
+		// give the stopped jobs a moment to exit so their final status is recorded
+		ctx, cancel := context.WithTimeout(context.Background(), stopGracePeriod)
+		defer cancel()
+		if err := w.WaitAll(ctx); err != nil {
+			log.Printf("error waiting for stopped jobs to exit: %v", err)
+		}
+	}
+
+	if conf.DrainState != "" {
+		if err := w.SaveState(conf.DrainState); err != nil {
+			log.Printf("error saving job state: %v", err)
+		} else {
+			log.Printf("saved job state to %s", conf.DrainState)
+		}
+	}
+}
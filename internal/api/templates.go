@@ -0,0 +1,121 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/rorski/grpc-job-manager/worker"
+)
+
+// jobTemplate is a job defined by admins in the templates file, which clients can start by name
+// with StartFromTemplate without being allowed to Start arbitrary commands. Args and the values
+// of Env can have ${param} placeholders, filled in with the params of the request.
+type jobTemplate struct {
+	Name    string              `json:"name"`
+	Cmd     string              `json:"cmd"`
+	Args    []string            `json:"args"`
+	Env     []string            `json:"env"`     // KEY=value
+	Cwd     string              `json:"cwd"`     // absolute path of the working directory
+	Cgroups worker.CgroupConfig `json:"cgroups"` // overrides of the default cgroup parameters
+	// Params maps the name of every placeholder to a regular expression its values must match
+	// entirely (e.g., "^[a-z]+$"), so clients can't pass arbitrary arguments to the command
+	Params map[string]string `json:"params"`
+
+	patterns map[string]*regexp.Regexp
+}
+
+// placeholder matches ${param} in the args and env of a template
+var placeholder = regexp.MustCompile(`\$\{([A-Za-z0-9_]+)\}`)
+
+// loadTemplates reads job templates from a JSON file like:
+//
+//	{"templates": [{"name": "backup", "cmd": "/usr/local/bin/backup", "args": ["--db", "${db}"],
+//	  "params": {"db": "^[a-z]+$"}, "cgroups": {"memory": {"memory.limit_in_bytes": "256M"}}}]}
+func loadTemplates(path string) (map[string]*jobTemplate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading templates file: %v", err)
+	}
+	var file struct {
+		Templates []*jobTemplate `json:"templates"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("error decoding templates file %s: %v", path, err)
+	}
+	templates := make(map[string]*jobTemplate, len(file.Templates))
+	for _, t := range file.Templates {
+		if err := t.compile(); err != nil {
+			return nil, fmt.Errorf("invalid template %q: %v", t.Name, err)
+		}
+		if _, ok := templates[t.Name]; ok {
+			return nil, fmt.Errorf("duplicate template %q", t.Name)
+		}
+		templates[t.Name] = t
+	}
+	return templates, nil
+}
+
+// compile checks a template and compiles the patterns of its params
+func (t *jobTemplate) compile() error {
+	if t.Name == "" || t.Cmd == "" {
+		return fmt.Errorf("name and cmd are required")
+	}
+	t.patterns = make(map[string]*regexp.Regexp, len(t.Params))
+	for name, pattern := range t.Params {
+		// anchor the pattern, so it always has to match the entire value
+		re, err := regexp.Compile(`^(?:` + pattern + `)$`)
+		if err != nil {
+			return fmt.Errorf("invalid pattern for param %s: %v", name, err)
+		}
+		t.patterns[name] = re
+	}
+	for _, s := range append(append([]string{}, t.Args...), t.Env...) {
+		for _, match := range placeholder.FindAllStringSubmatch(s, -1) {
+			if _, ok := t.patterns[match[1]]; !ok {
+				return fmt.Errorf("placeholder %s has no param", match[0])
+			}
+		}
+	}
+	return nil
+}
+
+// expand returns the args and env of a template with its placeholders filled in from params.
+// Every param of the template is required, and unknown params are rejected.
+func (t *jobTemplate) expand(params map[string]string) (args, env []string, err error) {
+	for name, value := range params {
+		re, ok := t.patterns[name]
+		if !ok {
+			return nil, nil, fmt.Errorf("unknown param %q for template %s", name, t.Name)
+		}
+		if !re.MatchString(value) {
+			return nil, nil, fmt.Errorf("value of param %s doesn't match %s", name, t.Params[name])
+		}
+	}
+	var missing []string
+	for name := range t.patterns {
+		if _, ok := params[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, nil, fmt.Errorf("missing params for template %s: %s", t.Name, strings.Join(missing, ", "))
+	}
+
+	replace := func(s string) string {
+		return placeholder.ReplaceAllStringFunc(s, func(match string) string {
+			return params[placeholder.FindStringSubmatch(match)[1]]
+		})
+	}
+	for _, arg := range t.Args {
+		args = append(args, replace(arg))
+	}
+	for _, kv := range t.Env {
+		env = append(env, replace(kv))
+	}
+	return args, env, nil
+}
@@ -0,0 +1,446 @@
+package api
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256" // hash functions used by the supported signing algorithms
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// jwksRefreshInterval is the minimum time between fetches of the JWKS when a token is signed
+	// with a key the server doesn't know yet (e.g., after the issuer rotated its keys)
+	jwksRefreshInterval = time.Minute
+	// tokenLeeway is the clock skew allowed when checking the exp and nbf claims of a token
+	tokenLeeway = time.Minute
+)
+
+// tokenVerifier authenticates clients with a JWT bearer token in the "authorization" metadata of
+// each call, as an alternative to client certificates. Tokens are verified against the keys
+// published by the issuer (JWKS), and the identity and role of the client are taken from claims
+// in the token.
+type tokenVerifier struct {
+	issuer        string // expected iss claim (not checked if empty)
+	audience      string // expected in the aud claim (not checked if empty)
+	identityClaim string // claim with the identity of the client, e.g., "sub" or "email"
+	roleClaim     string // claim with the role of the client, e.g., "role" or "realm_access.roles"
+	jwksURL       string
+	client        *http.Client
+
+	mu          sync.Mutex
+	keys        map[string]crypto.PublicKey // keys from the JWKS by key ID
+	lastRefresh time.Time
+}
+
+// newTokenVerifier creates a tokenVerifier from a Config and fetches the signing keys of the
+// issuer. If conf.TokenJWKS is empty, the JWKS URL is discovered from the OpenID configuration of
+// conf.TokenIssuer.
+func newTokenVerifier(conf Config) (*tokenVerifier, error) {
+	v := &tokenVerifier{
+		issuer:        conf.TokenIssuer,
+		audience:      conf.TokenAudience,
+		identityClaim: conf.TokenIdentityClaim,
+		roleClaim:     conf.TokenRoleClaim,
+		jwksURL:       conf.TokenJWKS,
+		client:        &http.Client{Timeout: 10 * time.Second},
+	}
+	if v.identityClaim == "" {
+		v.identityClaim = "sub"
+	}
+	if v.roleClaim == "" {
+		v.roleClaim = "role"
+	}
+	if v.jwksURL == "" {
+		if v.issuer == "" {
+			return nil, errors.New("a token issuer or JWKS URL is required for token authentication")
+		}
+		jwksURL, err := v.discoverJWKS()
+		if err != nil {
+			return nil, err
+		}
+		v.jwksURL = jwksURL
+	}
+	if err := v.refresh(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// discoverJWKS returns the jwks_uri from the OpenID configuration of the issuer
+func (v *tokenVerifier) discoverJWKS() (string, error) {
+	var config struct {
+		JWKSURI string `json:"jwks_uri"`
+	}
+	configURL := strings.TrimSuffix(v.issuer, "/") + "/.well-known/openid-configuration"
+	if err := v.getJSON(configURL, &config); err != nil {
+		return "", fmt.Errorf("error getting OpenID configuration: %v", err)
+	}
+	if config.JWKSURI == "" {
+		return "", fmt.Errorf("no jwks_uri in OpenID configuration at %s", configURL)
+	}
+	return config.JWKSURI, nil
+}
+
+func (v *tokenVerifier) getJSON(url string, out any) error {
+	res, err := v.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, res.Status)
+	}
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(out); err != nil {
+		return fmt.Errorf("error decoding %s: %v", url, err)
+	}
+	return nil
+}
+
+// jwk is a public key in a JWKS (RFC 7517). Only RSA and EC keys are supported.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// refresh fetches the JWKS and replaces the known signing keys. Keys that can't be parsed (e.g.,
+// unsupported key types) are skipped.
+func (v *tokenVerifier) refresh() error {
+	var jwks struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := v.getJSON(v.jwksURL, &jwks); err != nil {
+		return fmt.Errorf("error getting JWKS: %v", err)
+	}
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range jwks.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			log.Printf("skipping key %q in JWKS: %v", k.Kid, err)
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("no supported signing keys in JWKS at %s", v.jwksURL)
+	}
+	v.mu.Lock()
+	v.keys = keys
+	v.lastRefresh = time.Now()
+	v.mu.Unlock()
+	return nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("invalid EC public key")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("invalid key parameter")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// key returns the signing key with a key ID, refetching the JWKS (at most once per
+// jwksRefreshInterval) if the key is unknown. A token without a key ID can only be verified if
+// the JWKS has a single key.
+func (v *tokenVerifier) key(kid string) (crypto.PublicKey, error) {
+	v.mu.Lock()
+	key, ok := v.lookup(kid)
+	refresh := !ok && time.Since(v.lastRefresh) > jwksRefreshInterval
+	if refresh {
+		// only one call refreshes, others with the same unknown key fail until the next interval
+		v.lastRefresh = time.Now()
+	}
+	v.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	if refresh {
+		if err := v.refresh(); err != nil {
+			log.Printf("error refreshing JWKS: %v", err)
+		}
+		v.mu.Lock()
+		key, ok = v.lookup(kid)
+		v.mu.Unlock()
+		if ok {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// lookup must be called with v.mu held
+func (v *tokenVerifier) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(v.keys) == 1 {
+		for _, key := range v.keys {
+			return key, true
+		}
+	}
+	key, ok := v.keys[kid]
+	return key, ok
+}
+
+// signingAlgorithms maps the supported JWS algorithms to their hash functions. Symmetric (HS*)
+// algorithms and "none" are never accepted.
+var signingAlgorithms = map[string]crypto.Hash{
+	"RS256": crypto.SHA256, "RS384": crypto.SHA384, "RS512": crypto.SHA512,
+	"ES256": crypto.SHA256, "ES384": crypto.SHA384, "ES512": crypto.SHA512,
+}
+
+// verify checks the signature and claims of a token and returns the identity of the client
+func (v *tokenVerifier) verify(token string) (identity, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return identity{}, errors.New("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return identity{}, fmt.Errorf("malformed token header: %v", err)
+	}
+	hash, ok := signingAlgorithms[header.Alg]
+	if !ok {
+		return identity{}, fmt.Errorf("unsupported token algorithm %q", header.Alg)
+	}
+	key, err := v.key(header.Kid)
+	if err != nil {
+		return identity{}, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return identity{}, errors.New("malformed token signature")
+	}
+	h := hash.New()
+	h.Write([]byte(parts[0] + "." + parts[1]))
+	if err := verifySignature(header.Alg, key, h.Sum(nil), hash, signature); err != nil {
+		return identity{}, err
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return identity{}, fmt.Errorf("malformed token claims: %v", err)
+	}
+	if err := v.checkClaims(claims, time.Now()); err != nil {
+		return identity{}, err
+	}
+	id, _ := claimValue(claims, v.identityClaim).(string)
+	if id == "" {
+		return identity{}, fmt.Errorf("missing %q claim in token", v.identityClaim)
+	}
+	role := tokenRole(claimValue(claims, v.roleClaim))
+	if role == "" {
+		return identity{}, fmt.Errorf("no role in %q claim of token", v.roleClaim)
+	}
+	return identity{CN: id, Role: role}, nil
+}
+
+func decodeSegment(segment string, out any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+func verifySignature(alg string, key crypto.PublicKey, digest []byte, hash crypto.Hash, signature []byte) error {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if strings.HasPrefix(alg, "RS") && rsa.VerifyPKCS1v15(k, hash, digest, signature) == nil {
+			return nil
+		}
+	case *ecdsa.PublicKey:
+		// ES* signatures are the fixed size R and S values concatenated (RFC 7518, section 3.4)
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if strings.HasPrefix(alg, "ES") && len(signature) == 2*size {
+			r := new(big.Int).SetBytes(signature[:size])
+			s := new(big.Int).SetBytes(signature[size:])
+			if ecdsa.Verify(k, digest, r, s) {
+				return nil
+			}
+		}
+	}
+	return errors.New("invalid token signature")
+}
+
+// checkClaims checks the expiry, issuer and audience of a token. Tokens without an expiry are
+// rejected.
+func (v *tokenVerifier) checkClaims(claims map[string]any, now time.Time) error {
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return errors.New("missing exp claim in token")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(tokenLeeway)) {
+		return errors.New("token is expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(tokenLeeway).Before(time.Unix(int64(nbf), 0)) {
+		return errors.New("token is not valid yet")
+	}
+	if v.issuer != "" && claims["iss"] != v.issuer {
+		return fmt.Errorf("token issuer %v is not %q", claims["iss"], v.issuer)
+	}
+	if v.audience != "" {
+		// aud is either a single string or an array of strings
+		var audiences []any
+		switch aud := claims["aud"].(type) {
+		case string:
+			audiences = []any{aud}
+		case []any:
+			audiences = aud
+		}
+		for _, aud := range audiences {
+			if aud == v.audience {
+				return nil
+			}
+		}
+		return fmt.Errorf("token audience is not %q", v.audience)
+	}
+	return nil
+}
+
+// claimValue returns the value of a claim, following dots into nested objects (e.g.,
+// "realm_access.roles"). It returns nil if the claim doesn't exist.
+func claimValue(claims map[string]any, name string) any {
+	var value any = claims
+	for _, key := range strings.Split(name, ".") {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil
+		}
+		value = object[key]
+	}
+	return value
+}
+
+// tokenRole returns the role in a role claim: the claim itself if it is a string, or the first
+// role known to the server (see roleMap) if it is an array
+func tokenRole(claim any) string {
+	switch value := claim.(type) {
+	case string:
+		return value
+	case []any:
+		for _, v := range value {
+			if role, ok := v.(string); ok && isKnownRole(role) {
+				return role
+			}
+		}
+	}
+	return ""
+}
+
+func isKnownRole(role string) bool {
+	for _, roles := range roleMap {
+		for _, r := range roles {
+			if r == role {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// authenticate verifies the bearer token in the metadata of a call and adds the result to the
+// context, where clientIdentity finds it. Failures are not returned here, so the call is still
+// recorded in the audit log before it is rejected by the authorization interceptors.
+func (v *tokenVerifier) authenticate(ctx context.Context) context.Context {
+	var id identity
+	err := errors.New("missing bearer token")
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for _, value := range md.Get("authorization") {
+			if token, ok := cutPrefixFold(value, "Bearer "); ok {
+				id, err = v.verify(token)
+				break
+			}
+		}
+	}
+	if err != nil {
+		err = fmt.Errorf("invalid token: %v", err)
+	}
+	return context.WithValue(ctx, authKey{}, authResult{id: id, err: err})
+}
+
+// cutPrefixFold is strings.CutPrefix, ignoring the case of the prefix
+func cutPrefixFold(s, prefix string) (string, bool) {
+	if len(s) < len(prefix) || !strings.EqualFold(s[:len(prefix)], prefix) {
+		return s, false
+	}
+	return s[len(prefix):], true
+}
+
+// unaryInterceptor is a grpc interceptor that authenticates unary calls with a bearer token
+func (v *tokenVerifier) unaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	return handler(v.authenticate(ctx), req)
+}
+
+// streamInterceptor is a grpc interceptor that authenticates streaming calls with a bearer token
+func (v *tokenVerifier) streamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return handler(srv, &contextStream{ServerStream: ss, ctx: v.authenticate(ss.Context())})
+}
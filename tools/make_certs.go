@@ -13,15 +13,25 @@ import (
 	"time"
 )
 
+// randomSerial returns a random 128 bit serial number. Serial numbers need to be unique per CA
+// so individual certificates can be revoked in a CRL
+func randomSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
 // CreateCA creates a new CA with a one year expiration
 func CreateCA() error {
+	serial, err := randomSerial()
+	if err != nil {
+		return fmt.Errorf("error generating serial number: %v", err)
+	}
 	ca := &x509.Certificate{
-		SerialNumber:          big.NewInt(2022),
+		SerialNumber:          serial,
 		NotBefore:             time.Now(),
 		NotAfter:              time.Now().AddDate(1, 0, 0),
 		IsCA:                  true,
 		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
-		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
 		BasicConstraintsValid: true,
 	}
 	// create a 4096 bit private key
@@ -64,8 +74,12 @@ func CreateCA() error {
 // It takes the common name (cn) of the requested cert as an input and uses the
 // organization (o) as a role for authorizing the access of this certificate to run methods
 func CreateCert(cn, role string) error {
+	serial, err := randomSerial()
+	if err != nil {
+		return fmt.Errorf("error generating serial number: %v", err)
+	}
 	cert := &x509.Certificate{
-		SerialNumber: big.NewInt(2022),
+		SerialNumber: serial,
 		Subject: pkix.Name{
 			CommonName:   cn,
 			Organization: []string{role},
@@ -0,0 +1,191 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// archive states reported in Status.ArchiveStatus for jobs with an Archiver
+const (
+	ArchivePending   = "PENDING"   // waiting for the job to finish
+	ArchiveUploading = "UPLOADING" // output is being pushed to the archive
+	ArchiveDone      = "ARCHIVED"  // output was archived at Status.ArchiveLocation
+	ArchiveFailed    = "FAILED"    // archiving failed with Status.ArchiveError
+)
+
+// archiveTimeout bounds how long archiving the output of a single job can take
+const archiveTimeout = 5 * time.Minute
+
+// Archiver pushes the output of a finished job to an external sink
+type Archiver interface {
+	// Archive stores the output of a job read from r (size bytes) and returns where it was stored
+	Archive(ctx context.Context, uuid string, r io.Reader, size int64) (string, error)
+}
+
+// NewArchiver returns an Archiver for a sink URL:
+//   - file:///path/to/dir copies output files into a local archive directory
+//   - http(s)://host/path POSTs the output to the URL
+//   - s3://bucket/prefix PUTs the output to an S3 compatible object store (see newS3Archiver)
+func NewArchiver(sink string) (Archiver, error) {
+	u, err := url.Parse(sink)
+	if err != nil {
+		return nil, fmt.Errorf("invalid archive url %q: %v", sink, err)
+	}
+	switch u.Scheme {
+	case "file":
+		if u.Path == "" || !filepath.IsAbs(u.Path) {
+			return nil, fmt.Errorf("archive directory in %q must be an absolute path", sink)
+		}
+		return dirArchiver{dir: u.Path}, nil
+	case "http", "https":
+		return httpArchiver{url: sink, client: &http.Client{}}, nil
+	case "s3":
+		return newS3Archiver(u)
+	}
+	return nil, fmt.Errorf("unsupported archive url %q (expected file, http, https or s3)", sink)
+}
+
+// WithArchive archives the output of a job to a sink URL (see NewArchiver) once it finishes,
+// instead of the Config.Archiver. The URL has to start with one of Config.ArchiveAllow.
+func WithArchive(sink string) Option {
+	return func(job *Job) {
+		job.archiveURL = sink
+	}
+}
+
+// archiverFor returns the Archiver for a job: the one for its own archive URL if it has one,
+// or the default Config.Archiver (nil if output is not archived). The path of the URL is
+// cleaned before it is matched against Config.ArchiveAllow, so "file:///srv/archive/../etc"
+// doesn't match "file:///srv/archive/".
+func (c *Config) archiverFor(job *Job) (Archiver, error) {
+	if job.archiveURL == "" {
+		return c.Archiver, nil
+	}
+	u, err := url.Parse(job.archiveURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid archive url %q: %v", ErrInvalidJob, job.archiveURL, err)
+	}
+	if u.Path != "" {
+		u.Path, u.RawPath = path.Clean(u.Path)+"/", ""
+	}
+	sink := u.String()
+	allowed := false
+	for _, prefix := range c.ArchiveAllow {
+		if strings.HasPrefix(sink, prefix) {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return nil, fmt.Errorf("%w: archive url %q is not allowed", ErrInvalidJob, job.archiveURL)
+	}
+	archiver, err := NewArchiver(sink)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidJob, err)
+	}
+	return archiver, nil
+}
+
+// archive pushes the output of a finished job to its Archiver, recording the result in the
+// job status
+func (w *Worker) archive(job *Job) {
+	w.mu.Lock()
+	job.status.ArchiveStatus = ArchiveUploading
+	w.mu.Unlock()
+
+	location, err := w.archiveOutput(job)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err != nil {
+		log.Printf("error archiving output of job %s: %v", job.UUID, err)
+		job.status.ArchiveStatus = ArchiveFailed
+		job.status.ArchiveError = err.Error()
+		return
+	}
+	job.status.ArchiveStatus = ArchiveDone
+	job.status.ArchiveLocation = location
+}
+
+func (w *Worker) archiveOutput(job *Job) (string, error) {
+	f, err := os.Open(filepath.Join(w.Config.Outpath, job.UUID))
+	if err != nil {
+		return "", fmt.Errorf("error opening output file: %v", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", fmt.Errorf("error getting fileinfo on %s: %v", f.Name(), err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), archiveTimeout)
+	defer cancel()
+	return job.archiver.Archive(ctx, job.UUID, f, info.Size())
+}
+
+// dirArchiver copies output into a local directory (e.g., a mounted network share)
+type dirArchiver struct {
+	dir string
+}
+
+func (d dirArchiver) Archive(ctx context.Context, uuid string, r io.Reader, size int64) (string, error) {
+	if err := os.MkdirAll(d.dir, 0755); err != nil {
+		return "", fmt.Errorf("error creating archive directory %s: %v", d.dir, err)
+	}
+	// write to a temporary file first so a partial copy is never mistaken for the output
+	tmp, err := os.CreateTemp(d.dir, "."+uuid+"-*")
+	if err != nil {
+		return "", fmt.Errorf("error creating archive file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("error copying output to %s: %v", tmp.Name(), err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("error closing archive file %s: %v", tmp.Name(), err)
+	}
+	path := filepath.Join(d.dir, uuid)
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", fmt.Errorf("error renaming archive file: %v", err)
+	}
+	return path, nil
+}
+
+// httpArchiver POSTs output to a URL, with the job UUID in the X-Job-Uuid header
+type httpArchiver struct {
+	url    string
+	client *http.Client
+}
+
+func (h httpArchiver) Archive(ctx context.Context, uuid string, r io.Reader, size int64) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, r)
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("X-Job-Uuid", uuid)
+	res, err := h.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error posting output to %s: %v", h.url, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return "", fmt.Errorf("error posting output to %s: %s", h.url, res.Status)
+	}
+	// the sink can say where it stored the output
+	if location := res.Header.Get("Location"); location != "" {
+		return location, nil
+	}
+	return h.url, nil
+}
@@ -0,0 +1,197 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	cgroupPath      = "/sys/fs/cgroup"     // path to the top level cgroup v1 hierarchy
+	cgroupConfigEnv = "JOBMANAGER_CGROUPS" // environment variable passing the cgroup config to Rexec
+)
+
+// map of cgroup controllers to configured parameter files
+// these are hard coded but in production they would be configurable
+var cgroupParamsMap = CgroupConfig{
+	"blkio": {
+		"blkio.bfq.weight": "500",
+	},
+	"cpu,cpuacct": {
+		"cpu.shares": "128",
+	},
+	"memory": {
+		"memory.limit_in_bytes": "32M",
+	},
+	// no parameters, every job gets a freezer cgroup so it can be paused (see Worker.Pause)
+	"freezer": {},
+}
+
+// return a copy of the default cgroup config, which can be changed per job without
+// affecting cgroupParamsMap
+func defaultCgroupConfig() CgroupConfig {
+	config := make(CgroupConfig, len(cgroupParamsMap))
+	for controller, params := range cgroupParamsMap {
+		config[controller] = make(map[string]string, len(params))
+		for param, value := range params {
+			config[controller][param] = value
+		}
+	}
+	return config
+}
+
+// given a passed in path like "/sys/fs/cgroup/blkio/12345", create the correct
+// params file under that cgroup and add the process to cgroup.procs
+func configureCgroup(path string, params map[string]string) error {
+	// for every defined parameter in the controller, write that file with the
+	// appropriate setting from the cgroupParamsMap above
+	for param := range params {
+		paramsFile, err := os.OpenFile(filepath.Join(path, param), os.O_APPEND|os.O_WRONLY, 0555)
+		if err != nil {
+			return fmt.Errorf("error creating cgroup parameters file: %v", err)
+		}
+		if _, err = paramsFile.WriteString(params[param] + "\n"); err != nil {
+			return fmt.Errorf("error writing process to cgroup: %v", err)
+		}
+		if err = paramsFile.Close(); err != nil {
+			return fmt.Errorf("error closing cgroup parameters file %s: %v", paramsFile.Name(), err)
+		}
+	}
+
+	// write the process id to the cgroup.procs in this cgroup. Note the pid written
+	// will match the path, since we're doing a cgroup-per-process model
+	procsFile, err := os.OpenFile(filepath.Join(path, "cgroup.procs"), os.O_APPEND|os.O_WRONLY, 0555)
+	if err != nil {
+		return fmt.Errorf("error creating cgroup.procs file: %v", err)
+	}
+	defer procsFile.Close()
+	// writing "0" to a cgroup causes the writing process to be moved to that cgroup.
+	// see "Creating cgroups and moving processes": https://man7.org/linux/man-pages/man7/cgroups.7.html
+	if _, err = procsFile.WriteString(strconv.Itoa(0)); err != nil {
+		return fmt.Errorf("error writing process to cgroup: %v", err)
+	}
+
+	return nil
+}
+
+// create a new cgroup in each of the controllers in the config (by default blkio, cpu, and memory)
+// 1. Create <pid> under each of the cgroups
+// 2. add a cgroups.proc file and the relevant parameter file to each cgroup
+func createCgroup(pid string, config CgroupConfig) error {
+	for controller, params := range config {
+		cgroupPidPath := filepath.Join(cgroupPath, controller, pid)
+		if err := os.Mkdir(cgroupPidPath, 0555); err != nil {
+			return fmt.Errorf("error creating %s: %v", cgroupPidPath, err)
+		}
+		if err := configureCgroup(cgroupPidPath, params); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clean up (remove) the cgroup once the job is finished
+func removeCgroups(pid int, config CgroupConfig) error {
+	var errorStrings []string
+	for controller := range config {
+		// path to the cgroup for this process
+		cgroupPidPath := filepath.Join(cgroupPath, controller, strconv.Itoa(pid))
+		if err := os.RemoveAll(cgroupPidPath); err != nil {
+			errorStrings = append(errorStrings, fmt.Sprintf("error removing %s: %v", cgroupPidPath, err.Error()))
+		}
+	}
+	if len(errorStrings) != 0 {
+		return errors.New(strings.Join(errorStrings, " "))
+	}
+	return nil
+}
+
+// readUsage reads the resource usage of a job from its cgroups: CPU time from cpuacct.usage,
+// peak memory from memory.max_usage_in_bytes and IO from blkio.throttle.io_service_bytes.
+// Counters of controllers the job has no cgroup in, or that can't be read, are left at zero.
+func readUsage(pid int, config CgroupConfig) Usage {
+	var usage Usage
+	for controller := range config {
+		path := filepath.Join(cgroupPath, controller, strconv.Itoa(pid))
+		switch {
+		case strings.Contains(controller, "cpuacct"):
+			if ns, err := readCgroupInt(filepath.Join(path, "cpuacct.usage")); err == nil {
+				usage.CPUTime = time.Duration(ns)
+			}
+		case controller == "memory":
+			if bytes, err := readCgroupInt(filepath.Join(path, "memory.max_usage_in_bytes")); err == nil {
+				usage.PeakMemory = bytes
+			}
+		case controller == "blkio":
+			usage.IOReadBytes, usage.IOWriteBytes = readIOServiceBytes(filepath.Join(path, "blkio.throttle.io_service_bytes"))
+		}
+	}
+	return usage
+}
+
+// readCgroupInt reads a cgroup file holding a single integer
+func readCgroupInt(path string) (int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+}
+
+// readIOServiceBytes sums the bytes read and written on all devices in a blkio
+// io_service_bytes file, which has lines like "8:0 Read 4096"
+func readIOServiceBytes(path string) (read, write int64) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, 0
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue // skip the "Total" line, which has no device
+		}
+		value, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch fields[1] {
+		case "Read":
+			read += value
+		case "Write":
+			write += value
+		}
+	}
+	return read, write
+}
+
+// freeze freezes (or thaws) the processes in the freezer cgroup of a job, and waits for the
+// cgroup to reach the new state
+func freeze(pid int, frozen bool) error {
+	stateFile := filepath.Join(cgroupPath, "freezer", strconv.Itoa(pid), "freezer.state")
+	state := "THAWED"
+	if frozen {
+		state = "FROZEN"
+	}
+	if err := os.WriteFile(stateFile, []byte(state), 0644); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%w: no freezer cgroup for %d", ErrFreezerUnavailable, pid)
+		}
+		return fmt.Errorf("error writing %s: %v", stateFile, err)
+	}
+	// freezing is not immediate, the cgroup is FREEZING until all its processes are frozen
+	for i := 0; i < 100; i++ {
+		current, err := os.ReadFile(stateFile)
+		if err != nil {
+			return fmt.Errorf("error reading %s: %v", stateFile, err)
+		}
+		if strings.TrimSpace(string(current)) == state {
+			return nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return fmt.Errorf("timed out waiting for cgroup of %d to be %s", pid, state)
+}
@@ -0,0 +1,42 @@
+package worker
+
+import "sort"
+
+// Describe returns the configuration of a job, including the cgroup parameters written for it
+func (w *Worker) Describe(uuid string) (JobInfo, error) {
+	job, err := w.getJobByUUID(uuid)
+	if err != nil {
+		return JobInfo{}, err
+	}
+
+	return job.info(), nil
+}
+
+// List returns the configuration of all jobs known to the worker, most recently created first
+func (w *Worker) List() []JobInfo {
+	w.mu.RLock()
+	jobs := make([]JobInfo, 0, len(w.jobs))
+	for _, job := range w.jobs {
+		jobs = append(jobs, job.info())
+	}
+	w.mu.RUnlock()
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].Created.After(jobs[j].Created)
+	})
+	return jobs
+}
+
+func (job *Job) info() JobInfo {
+	return JobInfo{
+		UUID:    job.UUID,
+		Created: job.created,
+		Owner:   job.owner,
+		Parent:  job.parent,
+		Name:    job.name,
+		Args:    job.args,
+		Env:     job.env,
+		Dir:     job.dir,
+		Cgroups: job.cgroups,
+	}
+}
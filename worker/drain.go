@@ -0,0 +1,103 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+)
+
+// Drain stops the Worker from accepting new jobs, e.g. before shutting down. Start returns
+// ErrDraining from then on, and queued jobs are taken off the queue without being started.
+// Running jobs are not affected (see WaitAll and StopAll).
+func (w *Worker) Drain() {
+	w.mu.Lock()
+	w.draining = true
+	queued := w.queue
+	w.mu.Unlock()
+
+	for _, job := range queued {
+		w.dequeue(job)
+	}
+}
+
+// Draining returns true once Drain has been called
+func (w *Worker) Draining() bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.draining
+}
+
+// WaitAll blocks until all jobs have finished (or the context is done)
+func (w *Worker) WaitAll(ctx context.Context) error {
+	w.mu.RLock()
+	var pending []chan struct{}
+	for _, job := range w.jobs {
+		pending = append(pending, job.done)
+	}
+	w.mu.RUnlock()
+
+	for _, done := range pending {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// StopAll stops all jobs that are still running and returns the number of jobs stopped
+func (w *Worker) StopAll() int {
+	w.mu.RLock()
+	var uuids []string
+	for uuid, job := range w.jobs {
+		if job.cmd != nil && !job.status.Exited && job.status.ExitCode == 0 {
+			uuids = append(uuids, uuid)
+		}
+	}
+	w.mu.RUnlock()
+
+	stopped := 0
+	for _, uuid := range uuids {
+		if err := w.Stop(uuid); err != nil {
+			if !errors.Is(err, ErrJobAlreadyExited) {
+				log.Printf("error stopping job %s: %v", uuid, err)
+			}
+			continue
+		}
+		stopped++
+	}
+	return stopped
+}
+
+// jobRecord is the state of a job saved by SaveState
+type jobRecord struct {
+	JobInfo
+	Status Status
+}
+
+// SaveState writes the configuration and status of all jobs to path as JSON, most recently
+// created first, so job records are not lost when the server shuts down
+func (w *Worker) SaveState(path string) error {
+	var records []jobRecord
+	for _, info := range w.List() {
+		status, err := w.Status(info.UUID)
+		if err != nil {
+			// the process can disappear between listing and reading its state
+			log.Printf("error getting status of job %s: %v", info.UUID, err)
+		}
+		records = append(records, jobRecord{JobInfo: info, Status: status})
+	}
+
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding job state: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("error writing job state to %s: %v", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package worker
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// reservedEnv are environment variables a job can never set, since they configure the worker
+// itself (e.g., the cgroup config passed to Rexec)
+var reservedEnv = []string{"JOBMANAGER_*"}
+
+// DefaultEnvDeny are the environment variables jobs can't set by default, since they change
+// how every program in the job is loaded
+var DefaultEnvDeny = []string{"LD_*"}
+
+// Option configures a job started with Start
+type Option func(*Job)
+
+// WithEnv sets environment variables ("KEY=value") for a job, in addition to the environment
+// of the worker. They are checked against Config.EnvAllow and Config.EnvDeny by Start.
+func WithEnv(env []string) Option {
+	return func(job *Job) {
+		job.env = env
+	}
+}
+
+// WithOwner records the identity of the client starting a job (e.g., the common name of its
+// certificate), so callers can restrict access to the job to its owner
+func WithOwner(owner string) Option {
+	return func(job *Job) {
+		job.owner = owner
+	}
+}
+
+// WithDir sets the absolute path of the working directory of a job
+func WithDir(dir string) Option {
+	return func(job *Job) {
+		job.dir = dir
+	}
+}
+
+// WithCgroups overrides cgroup parameters of a job (e.g., {"memory": {"memory.limit_in_bytes":
+// "128M"}}), on top of the default config. Parameters can be added, but not removed.
+func WithCgroups(overrides CgroupConfig) Option {
+	return func(job *Job) {
+		// jobs have no cgroups on platforms without cgroup support (see defaultCgroupConfig)
+		if job.cgroups == nil {
+			return
+		}
+		for controller, params := range overrides {
+			if job.cgroups[controller] == nil {
+				job.cgroups[controller] = make(map[string]string, len(params))
+			}
+			for param, value := range params {
+				job.cgroups[controller][param] = value
+			}
+		}
+	}
+}
+
+// validate checks the environment, cgroup parameters and working directory of a job against
+// the Config
+func (c *Config) validate(job *Job) error {
+	for _, kv := range job.env {
+		key, _, ok := strings.Cut(kv, "=")
+		if !ok || key == "" {
+			return fmt.Errorf("%w: environment variable %q is not in KEY=value form", ErrInvalidJob, kv)
+		}
+		if matchesAny(reservedEnv, key) || matchesAny(c.EnvDeny, key) {
+			return fmt.Errorf("%w: environment variable %s is denied", ErrInvalidJob, key)
+		}
+		if len(c.EnvAllow) > 0 && !matchesAny(c.EnvAllow, key) {
+			return fmt.Errorf("%w: environment variable %s is not allowed", ErrInvalidJob, key)
+		}
+	}
+	// controllers and parameters are directory and file names under the cgroup hierarchy
+	for controller, params := range job.cgroups {
+		if !isFileName(controller) {
+			return fmt.Errorf("%w: invalid cgroup controller %q", ErrInvalidJob, controller)
+		}
+		for param := range params {
+			if !isFileName(param) {
+				return fmt.Errorf("%w: invalid cgroup parameter %q", ErrInvalidJob, param)
+			}
+		}
+	}
+	if job.dir == "" {
+		return nil
+	}
+	if !filepath.IsAbs(job.dir) {
+		return fmt.Errorf("%w: working directory %q is not an absolute path", ErrInvalidJob, job.dir)
+	}
+	// check the directory up front, since the error from starting the process doesn't say what's missing
+	if info, err := os.Stat(job.dir); err != nil || !info.IsDir() {
+		return fmt.Errorf("%w: working directory %s is not a directory", ErrInvalidJob, job.dir)
+	}
+	return nil
+}
+
+// matchesAny returns true if name matches one of the patterns (e.g., "LD_*", see path.Match)
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// isFileName returns true if name is a single path element, so it can't point outside a directory
+func isFileName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\`)
+}
@@ -0,0 +1,19 @@
+package worker
+
+import "errors"
+
+// Errors returned by the Worker. Callers (e.g., the gRPC API) can use errors.Is to
+// branch on the kind of failure rather than matching on error strings.
+var (
+	ErrJobNotFound        = errors.New("job not found")
+	ErrJobAlreadyExited   = errors.New("job already exited")
+	ErrCgroupSetup        = errors.New("cgroup setup failed")
+	ErrOutputMissing      = errors.New("job output missing")
+	ErrJobLimitReached    = errors.New("concurrent job limit reached")
+	ErrDraining           = errors.New("worker is draining")
+	ErrInvalidJob         = errors.New("invalid job")
+	ErrCommandDenied      = errors.New("command not allowed")
+	ErrJobQueued          = errors.New("job is queued")
+	ErrInvalidSignal      = errors.New("invalid signal")
+	ErrFreezerUnavailable = errors.New("freezer cgroup unavailable")
+)
@@ -0,0 +1,132 @@
+package worker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+// command returns the command running a job. The job is re-executed through /proc/self/exe
+// ("rexec", see Rexec) in isolated pid and mount namespaces, so it can add itself to its
+// cgroups before running the actual command.
+func command(job *Job) (*exec.Cmd, error) {
+	// pass the job's cgroup config to the re-executed process so it writes exactly what we recorded
+	cgroups, err := json.Marshal(job.cgroups)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding cgroup config: %v", err)
+	}
+
+	cmd := exec.Command("/proc/self/exe", append([]string{"rexec", job.name}, job.args...)...)
+	// the job's environment is passed through Rexec to the command. The cgroup config goes last
+	// so it can't be overridden.
+	cmd.Env = append(append(os.Environ(), job.env...), cgroupConfigEnv+"="+string(cgroups))
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		// create an isolated pid and mount namespace
+		Cloneflags:   syscall.CLONE_NEWPID | syscall.CLONE_NEWNS,
+		Unshareflags: syscall.CLONE_NEWNS,
+		Pdeathsig:    syscall.SIGKILL, // kill the child process if this parent dies (SIGTERM is forwarded by Rexec)
+	}
+	return cmd, nil
+}
+
+// cleanup removes the cgroups of a finished job
+func cleanup(job *Job, pid int) {
+	if err := removeCgroups(pid, job.cgroups); err != nil {
+		log.Printf("error removing cgroup directories for %d: %v\n", pid, err)
+	}
+}
+
+// Rexec re-executes a command and places it in the same cgroup as its parent
+func Rexec(name string, args []string) error {
+	// Get the parent process (/proc/self/exe rexec ...) PID to use for creating a cgroup of the same name
+	processState, err := parseProcStat("self")
+	if err != nil {
+		return err
+	}
+	// use the cgroup config recorded for the job by Start, falling back to the defaults
+	config := cgroupParamsMap
+	if env, ok := os.LookupEnv(cgroupConfigEnv); ok {
+		config = CgroupConfig{}
+		if err := json.Unmarshal([]byte(env), &config); err != nil {
+			return fmt.Errorf("%w: error decoding cgroup config: %v", ErrCgroupSetup, err)
+		}
+		// don't leak the config into the job's environment
+		if err := os.Unsetenv(cgroupConfigEnv); err != nil {
+			return err
+		}
+	}
+	if err := createCgroup(processState.PID, config); err != nil {
+		return fmt.Errorf("%w: error adding job to cgroup: %v", ErrCgroupSetup, err)
+	}
+
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		// terminate the child process if this parent dies
+		Pdeathsig: syscall.SIGKILL,
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	// forward the signals sent to jobs (see Worker.Signal) to the command. As the init process
+	// of its pid namespace, this process would otherwise ignore them.
+	forward := make(chan os.Signal, 1)
+	for _, sig := range signals {
+		if sig != syscall.SIGKILL {
+			signal.Notify(forward, sig)
+		}
+	}
+	go func() {
+		for sig := range forward {
+			if err := cmd.Process.Signal(sig); err != nil {
+				log.Printf("error forwarding %v to %d: %v", sig, cmd.Process.Pid, err)
+			}
+		}
+	}()
+
+	return cmd.Wait()
+}
+
+// processState returns the state of a running process (RUNNING, STOPPED or ZOMBIE) from /proc
+func processState(pid int) (string, error) {
+	processStat, err := parseProcStat(strconv.Itoa(pid))
+	if err != nil {
+		return "", err
+	}
+	switch processStat.State {
+	case "R", "S", "D":
+		return "RUNNING", nil
+	case "Z":
+		return "ZOMBIE", nil
+	case "T":
+		return "STOPPED", nil
+	}
+	return processStat.State, nil
+}
+
+// parse the /proc/<pid>/stat file to get information about a process. This is used
+// to get the process state for processState() and the PID for Rexec()
+// Note that pid here is a string because it could be "self"
+// See: /proc/[pid]/stat section of https://man7.org/linux/man-pages/man5/proc.5.html
+func parseProcStat(pid string) (stat ProcessStat, err error) {
+	stats, err := os.ReadFile(filepath.Join("/", "proc", pid, "stat"))
+	if err != nil {
+		return ProcessStat{}, fmt.Errorf("error reading /proc/%s/stat: %v", pid, err)
+	}
+
+	var ignoreString string
+	if _, err := fmt.Fscan(bytes.NewBuffer(stats), &stat.PID, &ignoreString, &stat.State); err != nil {
+		return ProcessStat{}, err
+	}
+
+	return stat, err
+}
@@ -0,0 +1,52 @@
+//go:build !linux
+
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// errNotSupported is returned by Rexec on platforms without namespaces and cgroups
+var errNotSupported = errors.New("not supported on this platform")
+
+// command returns the command running a job. Without namespaces and cgroups, outside of Linux
+// the command is run directly, with no isolation or resource limits. This is meant for
+// developing and testing on macOS and Windows, not for running untrusted jobs.
+func command(job *Job) (*exec.Cmd, error) {
+	cmd := exec.Command(job.name, job.args...)
+	cmd.Env = append(os.Environ(), job.env...)
+	return cmd, nil
+}
+
+// cleanup has nothing to clean up for a finished job outside of Linux
+func cleanup(job *Job, pid int) {}
+
+// readUsage returns no usage outside of Linux, where jobs have no cgroups. The CPU time of
+// finished jobs is still recorded from the process (see Worker.run).
+func readUsage(pid int, config CgroupConfig) Usage {
+	return Usage{}
+}
+
+// freeze is not supported outside of Linux, where jobs have no freezer cgroup
+func freeze(pid int, frozen bool) error {
+	return fmt.Errorf("%w: %v", ErrFreezerUnavailable, errNotSupported)
+}
+
+// Rexec is only used to set up cgroups and namespaces on Linux
+func Rexec(name string, args []string) error {
+	return errNotSupported
+}
+
+// defaultCgroupConfig returns no cgroup config, since cgroups are not applied outside of Linux
+func defaultCgroupConfig() CgroupConfig {
+	return nil
+}
+
+// processState returns the state of a process that has not exited yet. Without /proc, a
+// started process is considered RUNNING until it is reaped (see Worker.run).
+func processState(pid int) (string, error) {
+	return "RUNNING", nil
+}
@@ -2,13 +2,12 @@ package worker
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
-	"unsafe"
-
-	"golang.org/x/sys/unix"
 )
 
 // Output takes a context and UUID and returns a channel of data from the output file
@@ -23,6 +22,9 @@ func (w *Worker) Output(ctx context.Context, uuid string) (chan []byte, error) {
 	outFilePath := filepath.Join(w.Config.Outpath, uuid)
 	f, err := os.Open(outFilePath)
 	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: %s", ErrOutputMissing, outFilePath)
+		}
 		return nil, err
 	}
 	dataStream := make(chan []byte)
@@ -36,9 +38,9 @@ func (w *Worker) Output(ctx context.Context, uuid string) (chan []byte, error) {
 			close(dataStream)
 		}()
 
-		// listen for filesystem events from the eventStream and read data to the
-		// dataStream if the event is an IN_MODIFY (i.e., a write to the output file)
-		eventStream, err := watch(ctx, outFilePath)
+		// listen for modify events (i.e., writes to the output file) from the eventStream
+		// and read data to the dataStream
+		eventStream, err := w.Config.Watcher.Watch(ctx, outFilePath)
 		if err != nil {
 			log.Printf("error watching for file events: %v", err)
 			return
@@ -59,7 +61,7 @@ func (w *Worker) Output(ctx context.Context, uuid string) (chan []byte, error) {
 		}
 		for {
 			if err := waitForModifyEvent(ctx, eventStream); err != nil {
-				log.Printf("error waiting for IN_MODIFY event: %v", err)
+				log.Printf("error waiting for modify event: %v", err)
 				return
 			}
 			if err := w.readChunk(ctx, f, dataStream); err != nil {
@@ -74,86 +76,56 @@ func (w *Worker) Output(ctx context.Context, uuid string) (chan []byte, error) {
 	return dataStream, nil
 }
 
-// Watch watches a file for IN_MODIFY events when it is written to.
-// Note that this will not catch if the file is closed/moved because we are not
-// watching for those events.
-//
-// See:
-// https://linux.die.net/man/1/inotifywait
-// https://pkg.go.dev/github.com/fsnotify/fsnotify
-// https://efreitasn.dev/posts/inotify-api/
-func watch(ctx context.Context, outFilePath string) (chan uint32, error) {
-	fd, err := unix.InotifyInit()
+// ReadOutput reads up to limit bytes (Config.ChunkSize if limit is 0) of the output of a job,
+// starting at offset. It returns the data, the offset to read the next chunk from and whether
+// the end of the output was reached after the job finished, i.e., no more data will come.
+func (w *Worker) ReadOutput(uuid string, offset int64, limit int) ([]byte, int64, bool, error) {
+	job, err := w.getJobByUUID(uuid)
 	if err != nil {
-		return nil, err
+		return nil, 0, false, err
 	}
-	// add inotifywatch for IN_MODIFY events on a file
-	wd, err := unix.InotifyAddWatch(fd, outFilePath, unix.IN_MODIFY)
+	if offset < 0 || limit < 0 {
+		return nil, 0, false, fmt.Errorf("invalid offset %d or limit %d", offset, limit)
+	}
+	if limit == 0 {
+		limit = w.Config.ChunkSize
+	}
+	// check whether the job has finished before reading, so output written in between isn't missed
+	finished := false
+	select {
+	case <-job.done:
+		finished = true
+	default:
+	}
+
+	outFilePath := filepath.Join(w.Config.Outpath, uuid)
+	f, err := os.Open(outFilePath)
 	if err != nil {
-		if err := unix.Close(fd); err != nil {
-			log.Printf("error closing file descriptor: %v", err)
+		if os.IsNotExist(err) {
+			return nil, 0, false, fmt.Errorf("%w: %s", ErrOutputMissing, outFilePath)
 		}
-		return nil, err
+		return nil, 0, false, err
 	}
+	defer f.Close()
 
-	// channel for parsing inotify Masks - https://pkg.go.dev/golang.org/x/sys/unix#InotifyEvent
-	eventStream := make(chan uint32)
-	go func() {
-		defer func() {
-			// remove the watch when we're done
-			success, err := unix.InotifyRmWatch(fd, uint32(wd))
-			if success == -1 || err != nil {
-				log.Printf("error removing inotify watch: %v", err)
-			}
-			if err := unix.Close(fd); err != nil {
-				log.Printf("error closing file descriptor: %v", err)
-			}
-			close(eventStream)
-		}()
-
-		// read events from the fd
-		// see "Reading Events" from https://efreitasn.dev/posts/inotify-api/
-		var buf [(unix.SizeofInotifyEvent + unix.NAME_MAX + 1) * 20]byte
-		for {
-			n, err := unix.Read(fd, buf[:])
-			if err != nil {
-				log.Printf("error reading from fd: %v", err)
-				return
-			}
-			offset := 0
-			for offset <= n-unix.SizeofInotifyEvent {
-				rawEvent := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
-				offset += unix.SizeofInotifyEvent + int(rawEvent.Len)
-				// if this is not an IN_MODIFY event, continue to next "for" iteration
-				if rawEvent.Mask&unix.IN_MODIFY != unix.IN_MODIFY {
-					continue
-				}
-				// otherwise, send it to the eventStream
-				select {
-				case eventStream <- rawEvent.Mask:
-				case <-ctx.Done():
-					return
-				}
-			}
-		}
-	}()
-	return eventStream, nil
+	data := make([]byte, limit)
+	n, err := f.ReadAt(data, offset)
+	if err != nil && err != io.EOF {
+		return nil, 0, false, fmt.Errorf("error reading output file %s: %v", outFilePath, err)
+	}
+	return data[:n], offset + int64(n), finished && err == io.EOF, nil
 }
 
-// waitForModifyEvent waits for IN_MODIFY events on the eventStream channel
-func waitForModifyEvent(ctx context.Context, eventStream chan uint32) error {
-	for {
-		select {
-		case event, ok := <-eventStream:
-			if !ok {
-				log.Print("eventStream channel closed")
-			}
-			if event&unix.IN_MODIFY == unix.IN_MODIFY {
-				return nil
-			}
-		case <-ctx.Done():
-			return ctx.Err()
+// waitForModifyEvent waits for a modify event on the eventStream channel
+func waitForModifyEvent(ctx context.Context, eventStream <-chan struct{}) error {
+	select {
+	case _, ok := <-eventStream:
+		if !ok {
+			return errors.New("eventStream channel closed")
 		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
 
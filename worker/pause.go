@@ -0,0 +1,39 @@
+package worker
+
+import "fmt"
+
+// Pause freezes the processes of a running job with the freezer cgroup, so the job stops using
+// CPU without losing its progress, and is reported as PAUSED until Resume is called. Queued
+// jobs return ErrJobQueued, and jobs that already exited ErrJobAlreadyExited.
+func (w *Worker) Pause(uuid string) error {
+	return w.setPaused(uuid, true)
+}
+
+// Resume thaws the processes of a job paused with Pause
+func (w *Worker) Resume(uuid string) error {
+	return w.setPaused(uuid, false)
+}
+
+func (w *Worker) setPaused(uuid string, paused bool) error {
+	job, err := w.getJobByUUID(uuid)
+	if err != nil {
+		return fmt.Errorf("error getting job: %w", err)
+	}
+	w.mu.RLock()
+	started, exited := job.cmd != nil, job.status.Exited || job.status.ExitCode != 0
+	w.mu.RUnlock()
+	if !started {
+		return fmt.Errorf("%w: %s", ErrJobQueued, uuid)
+	}
+	if exited {
+		return fmt.Errorf("%w: %s", ErrJobAlreadyExited, uuid)
+	}
+
+	if err := freeze(job.pid, paused); err != nil {
+		return err
+	}
+	w.mu.Lock()
+	job.paused = paused
+	w.mu.Unlock()
+	return nil
+}
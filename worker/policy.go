@@ -0,0 +1,41 @@
+package worker
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+)
+
+// checkCommand enforces Config.CommandAllow and Config.CommandDeny on a job. The command is
+// resolved to an absolute path with the PATH of the worker (not the job's, which the client
+// controls), and the job runs that exact path, so the binary that was checked is the one that
+// runs. Both the path and the file it links to are matched: the command is denied if either
+// matches a CommandDeny pattern, and only allowed if both match a CommandAllow pattern, since
+// some binaries act on the name they are run as (e.g., /sbin/reboot linking to systemctl).
+func (c *Config) checkCommand(job *Job) error {
+	if len(c.CommandAllow) == 0 && len(c.CommandDeny) == 0 {
+		return nil
+	}
+	name, err := exec.LookPath(job.name)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrCommandDenied, err)
+	}
+	if name, err = filepath.Abs(name); err != nil {
+		return fmt.Errorf("%w: %v", ErrCommandDenied, err)
+	}
+	paths := []string{name}
+	if target, err := filepath.EvalSymlinks(name); err == nil && target != name {
+		paths = append(paths, target)
+	}
+
+	for _, p := range paths {
+		if matchesAny(c.CommandDeny, p) {
+			return fmt.Errorf("%w: %s is denied", ErrCommandDenied, p)
+		}
+		if len(c.CommandAllow) > 0 && !matchesAny(c.CommandAllow, p) {
+			return fmt.Errorf("%w: %s is not allowed", ErrCommandDenied, p)
+		}
+	}
+	job.name = name
+	return nil
+}
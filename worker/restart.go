@@ -0,0 +1,25 @@
+package worker
+
+import "fmt"
+
+// Restart starts a new job with the same command, arguments, environment, working directory,
+// cgroup parameters and archive URL as an existing job, and returns the UUID of the new job.
+// The new job records the existing one as its parent. Options (e.g., WithOwner) are applied
+// on top, and the job is checked against the Config like any other job started with Start.
+func (w *Worker) Restart(uuid string, opts ...Option) (string, error) {
+	parent, err := w.getJobByUUID(uuid)
+	if err != nil {
+		return "", fmt.Errorf("error getting job: %w", err)
+	}
+	w.mu.RLock()
+	info, archiveURL := parent.info(), parent.archiveURL
+	w.mu.RUnlock()
+
+	return w.Start(info.Name, info.Args, append([]Option{
+		WithEnv(info.Env),
+		WithDir(info.Dir),
+		WithCgroups(info.Cgroups),
+		WithArchive(archiveURL),
+		func(job *Job) { job.parent = uuid },
+	}, opts...)...)
+}
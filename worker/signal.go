@@ -0,0 +1,52 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ParseSignal returns the signal with a name like "HUP" or "SIGHUP" (in any case), or a
+// number like "1". Only the signals that can be sent to jobs (see signals) are accepted, and
+// ErrInvalidSignal is returned for others.
+func ParseSignal(name string) (os.Signal, error) {
+	if n, err := strconv.Atoi(name); err == nil {
+		for _, sig := range signals {
+			if int(sig) == n {
+				return sig, nil
+			}
+		}
+		return nil, fmt.Errorf("%w: %s", ErrInvalidSignal, name)
+	}
+	sig, ok := signals[strings.TrimPrefix(strings.ToUpper(name), "SIG")]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidSignal, name)
+	}
+	return sig, nil
+}
+
+// Signal sends a signal to the process of a running job. Unlike Stop, the job is not marked
+// as terminated, even if the signal ends it. Queued jobs have no process to signal yet, and
+// return ErrJobQueued.
+func (w *Worker) Signal(uuid string, sig os.Signal) error {
+	job, err := w.getJobByUUID(uuid)
+	if err != nil {
+		return fmt.Errorf("error getting job: %w", err)
+	}
+	w.mu.RLock()
+	cmd := job.cmd
+	w.mu.RUnlock()
+	if cmd == nil {
+		return fmt.Errorf("%w: %s", ErrJobQueued, uuid)
+	}
+
+	if err := cmd.Process.Signal(sig); err != nil {
+		if errors.Is(err, os.ErrProcessDone) {
+			return fmt.Errorf("%w: %s", ErrJobAlreadyExited, uuid)
+		}
+		return fmt.Errorf("error sending %v to process: %v", sig, err)
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+//go:build !windows
+
+package worker
+
+import "syscall"
+
+// signals that can be sent to jobs with Signal, by name without the SIG prefix. SIGSTOP is
+// left out, since on Linux it would only stop the process running the job and not the
+// command (see Rexec); SIGTSTP can be used instead.
+var signals = map[string]syscall.Signal{
+	"HUP":   syscall.SIGHUP,
+	"INT":   syscall.SIGINT,
+	"QUIT":  syscall.SIGQUIT,
+	"ABRT":  syscall.SIGABRT,
+	"KILL":  syscall.SIGKILL,
+	"USR1":  syscall.SIGUSR1,
+	"USR2":  syscall.SIGUSR2,
+	"ALRM":  syscall.SIGALRM,
+	"TERM":  syscall.SIGTERM,
+	"CONT":  syscall.SIGCONT,
+	"TSTP":  syscall.SIGTSTP,
+	"WINCH": syscall.SIGWINCH,
+}
@@ -0,0 +1,8 @@
+package worker
+
+import "syscall"
+
+// signals that can be sent to jobs with Signal. Windows processes can only be killed.
+var signals = map[string]syscall.Signal{
+	"KILL": syscall.SIGKILL,
+}
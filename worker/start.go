@@ -1,123 +1,213 @@
 package worker
 
 import (
-	"errors"
 	"fmt"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"strconv"
-	"strings"
-	"syscall"
+	"time"
 
 	"github.com/google/uuid"
 )
 
-const cgroupPath = "/sys/fs/cgroup" // path to the top level cgroup v1 hierarchy
-
-// map of cgroup controllers to configured parameter files
-// these are hard coded but in production they would be configurable
-var cgroupParamsMap = map[string]map[string]string{
-	"blkio": {
-		"blkio.bfq.weight": "500",
-	},
-	"cpu,cpuacct": {
-		"cpu.shares": "128",
-	},
-	"memory": {
-		"memory.limit_in_bytes": "32M",
-	},
-}
-
-// Start creates a new process
-func (w *Worker) Start(name string, args []string) (string, error) {
+// Start creates a new process. If the Worker is already running Config.MaxJobs jobs, the
+// job is either rejected with ErrJobLimitReached or, if Config.QueueJobs is set, queued
+// and started once a running job finishes. Once the Worker is draining, all jobs are
+// rejected with ErrDraining. Options (e.g., WithEnv) that fail validation against the
+// Config are rejected with ErrInvalidJob, and commands not allowed by Config.CommandAllow
+// and Config.CommandDeny with ErrCommandDenied. If the job has an Archiver, its output is
+// archived once it finishes.
+func (w *Worker) Start(name string, args []string, opts ...Option) (string, error) {
 	// create a unique ID to identify the process, since a process ID could be reused
 	uniqueJobId := uuid.NewString()
-	outfile, err := createOutFile(uniqueJobId)
+	job := &Job{
+		UUID:    uniqueJobId,
+		created: time.Now(),
+		name:    name,
+		args:    args,
+		cgroups: defaultCgroupConfig(),
+		done:    make(chan struct{}),
+		status: &Status{
+			Terminated: false,
+		},
+	}
+	for _, opt := range opts {
+		opt(job)
+	}
+	if err := w.Config.validate(job); err != nil {
+		return "", err
+	}
+	if err := w.Config.checkCommand(job); err != nil {
+		return "", err
+	}
+	archiver, err := w.Config.archiverFor(job)
+	if err != nil {
+		return "", err
+	}
+	if archiver != nil {
+		job.archiver = archiver
+		job.status.ArchiveStatus = ArchivePending
+	}
+
+	w.mu.Lock()
+	if w.draining {
+		w.mu.Unlock()
+		return "", ErrDraining
+	}
+	if w.Config.MaxJobs > 0 && w.running >= w.Config.MaxJobs {
+		if !w.Config.QueueJobs {
+			w.mu.Unlock()
+			return "", fmt.Errorf("%w: %d jobs running", ErrJobLimitReached, w.running)
+		}
+		// create the output file up front so clients can wait on the output of a queued job
+		outfile, err := createOutFile(uniqueJobId)
+		if err != nil {
+			w.mu.Unlock()
+			return "", fmt.Errorf("error creating temp file: %v", err)
+		}
+		if err = outfile.Close(); err != nil {
+			log.Printf("error closing output file %s: %v", outfile.Name(), err)
+		}
+		job.status.State = "QUEUED"
+		w.jobs[uniqueJobId] = job
+		w.queue = append(w.queue, job)
+		w.mu.Unlock()
+		log.Printf("queued job: %s\n", uniqueJobId)
+		return job.UUID, nil
+	}
+	// reserve a slot for this job before starting it
+	w.running++
+	w.mu.Unlock()
+
+	if err := w.run(job); err != nil {
+		w.release()
+		return "", err
+	}
+	return job.UUID, nil
+}
+
+// run starts the process for a job, adds it to the jobs map and waits for it to complete
+// in the background. The caller must have reserved a running slot for the job, which is
+// released when the process exits.
+func (w *Worker) run(job *Job) error {
+	outfile, err := createOutFile(job.UUID)
 	if err != nil {
 		if closeErr := outfile.Close(); err != nil {
 			log.Printf("error closing output file: %v", closeErr)
 		}
-		return "", fmt.Errorf("error creating temp file: %v", err)
+		return fmt.Errorf("error creating temp file: %v", err)
 	}
 
-	// pass in /proc/self/exe so we re-execute this process in an isolated namespace with cgroup restrictions
-	cmd := exec.Command("/proc/self/exe", append([]string{"rexec", name}, args...)...)
+	cmd, err := command(job)
+	if err != nil {
+		if closeErr := outfile.Close(); closeErr != nil {
+			log.Printf("error closing output file %s: %v", outfile.Name(), closeErr)
+		}
+		return err
+	}
+	cmd.Dir = job.dir
 	cmd.Stdout = outfile
 	cmd.Stderr = outfile
-	cmd.SysProcAttr = &syscall.SysProcAttr{
-		// create an isolated pid and mount namespace
-		Cloneflags:   syscall.CLONE_NEWPID | syscall.CLONE_NEWNS,
-		Unshareflags: syscall.CLONE_NEWNS,
-		Pdeathsig:    syscall.SIGTERM, // terminate the child process if this parent dies
-	}
-	log.Printf("created job: %s\n", uniqueJobId)
+	log.Printf("created job: %s\n", job.UUID)
 	if err := cmd.Start(); err != nil {
-		return "", fmt.Errorf("error running command: %v", err)
+		if closeErr := outfile.Close(); closeErr != nil {
+			log.Printf("error closing output file %s: %v", outfile.Name(), closeErr)
+		}
+		return fmt.Errorf("error running command: %v", err)
 	}
 
-	// create new Job with the details of this job and add to the Jobs map
-	job := &Job{
-		UUID: uniqueJobId,
-		cmd:  cmd,
-		pid:  cmd.Process.Pid,
-		status: &Status{
-			Terminated: false,
-		},
-	}
+	// add the details of this job to the Jobs map
 	w.mu.Lock()
-	w.jobs[uniqueJobId] = job
+	job.cmd = cmd
+	job.pid = cmd.Process.Pid
+	job.status.StartedAt = time.Now()
+	w.jobs[job.UUID] = job
 	w.mu.Unlock()
 
 	// wait for process to complete in the background
 	go func() {
-		if err = cmd.Wait(); err != nil {
+		if err := cmd.Wait(); err != nil {
 			log.Printf("job finished with error: %v\n", err)
 		}
 		log.Printf("job finished at pid: %d\n", cmd.Process.Pid)
+		// with SyncOutput, make sure all output is on disk before the job is reported as exited
+		if w.Config.SyncOutput {
+			w.closeOutFile(job, outfile)
+		}
+		finished := time.Now()
+		// read the usage before cleanup removes the cgroups
+		usage := readUsage(cmd.Process.Pid, job.cgroups)
+		if usage.CPUTime == 0 {
+			usage.CPUTime = cmd.ProcessState.UserTime() + cmd.ProcessState.SystemTime()
+		}
 		w.mu.Lock()
+		job.status.Usage = usage
+		job.status.FinishedAt = finished
+		job.status.Duration = finished.Sub(job.status.StartedAt)
 		// update the status with the exit code of the process
 		job.status.ExitCode = job.cmd.ProcessState.ExitCode()
 		job.status.Exited = job.cmd.ProcessState.Exited()
 		w.mu.Unlock()
 
-		// clean up cgroups after the job completes
-		if err = removeCgroups(cmd.Process.Pid); err != nil {
-			log.Printf("error removing cgroup directories for %d: %v\n", cmd.Process.Pid, err)
+		// clean up after the job completes (e.g., remove its cgroups)
+		cleanup(job, cmd.Process.Pid)
+		if !w.Config.SyncOutput {
+			w.closeOutFile(job, outfile)
 		}
-		if err = outfile.Close(); err != nil {
-			log.Printf("error closing output file %s: %v", outfile.Name(), err)
+		close(job.done)
+		if job.archiver != nil {
+			go w.archive(job)
 		}
+		w.release()
 	}()
 
-	return job.UUID, nil
+	return nil
 }
 
-// Rexec re-executes a command and places it in the same cgroup as its parent
-func Rexec(name string, args []string) error {
-	// Get the parent process (/proc/self/exe rexec ...) PID to use for creating a cgroup of the same name
-	processState, err := parseProcStat("self")
-	if err != nil {
-		return err
+// closeOutFile closes the output file of a finished job and records its final size.
+// With SyncOutput, the file is also flushed to disk first.
+func (w *Worker) closeOutFile(job *Job, outfile *os.File) {
+	if w.Config.SyncOutput {
+		if err := outfile.Sync(); err != nil {
+			log.Printf("error syncing output file %s: %v", outfile.Name(), err)
+		}
 	}
-	if err := createCgroup(processState.PID); err != nil {
-		return fmt.Errorf("error adding job to cgroup: %v", err)
+	if info, err := outfile.Stat(); err != nil {
+		log.Printf("error getting fileinfo on %s: %v", outfile.Name(), err)
+	} else {
+		w.mu.Lock()
+		job.status.OutputSize = info.Size()
+		w.mu.Unlock()
 	}
-
-	cmd := exec.Command(name, args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.SysProcAttr = &syscall.SysProcAttr{
-		// terminate the child process if this parent dies
-		Pdeathsig: syscall.SIGKILL,
+	if err := outfile.Close(); err != nil {
+		log.Printf("error closing output file %s: %v", outfile.Name(), err)
 	}
+}
 
-	if err := cmd.Run(); err != nil {
-		return err
-	}
+// release frees a running slot and starts the next queued job, if there is one
+func (w *Worker) release() {
+	for {
+		w.mu.Lock()
+		if len(w.queue) == 0 {
+			w.running--
+			w.mu.Unlock()
+			return
+		}
+		// hand the freed slot straight to the next job in the queue
+		next := w.queue[0]
+		w.queue = w.queue[1:]
+		w.mu.Unlock()
 
-	return nil
+		err := w.run(next)
+		if err == nil {
+			return
+		}
+		log.Printf("error starting queued job %s: %v", next.UUID, err)
+		w.mu.Lock()
+		next.status.ExitCode = -1
+		w.mu.Unlock()
+		close(next.done)
+	}
 }
 
 // create the output file for a job. If the jobmanager directory (/tmp/jobmanager) doesn't exist, create it.
@@ -137,69 +227,3 @@ func createOutFile(uuid string) (*os.File, error) {
 
 	return os.OpenFile(filepath.Join(jobsDir, uuid), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
 }
-
-// given a passed in path like "/sys/fs/cgroup/blkio/12345", create the correct
-// params file under that cgroup and add the process to cgroup.procs
-func configureCgroup(path string, params map[string]string) error {
-	// for every defined parameter in the controller, write that file with the
-	// appropriate setting from the cgroupParamsMap above
-	for param := range params {
-		paramsFile, err := os.OpenFile(filepath.Join(path, param), os.O_APPEND|os.O_WRONLY, 0555)
-		if err != nil {
-			return fmt.Errorf("error creating cgroup parameters file: %v", err)
-		}
-		if _, err = paramsFile.WriteString(params[param] + "\n"); err != nil {
-			return fmt.Errorf("error writing process to cgroup: %v", err)
-		}
-		if err = paramsFile.Close(); err != nil {
-			return fmt.Errorf("error closing cgroup parameters file %s: %v", paramsFile.Name(), err)
-		}
-	}
-
-	// write the process id to the cgroup.procs in this cgroup. Note the pid written
-	// will match the path, since we're doing a cgroup-per-process model
-	procsFile, err := os.OpenFile(filepath.Join(path, "cgroup.procs"), os.O_APPEND|os.O_WRONLY, 0555)
-	if err != nil {
-		return fmt.Errorf("error creating cgroup.procs file: %v", err)
-	}
-	defer procsFile.Close()
-	// writing "0" to a cgroup causes the writing process to be moved to that cgroup.
-	// see "Creating cgroups and moving processes": https://man7.org/linux/man-pages/man7/cgroups.7.html
-	if _, err = procsFile.WriteString(strconv.Itoa(0)); err != nil {
-		return fmt.Errorf("error writing process to cgroup: %v", err)
-	}
-
-	return nil
-}
-
-// create a new cgroup in each of the three controllers: blkio, cpu, and memory
-// 1. Create <pid> under each of the three cgroups
-// 2. add a cgroups.proc file and the relevant parameter file to each cgroup
-func createCgroup(pid string) error {
-	for controller, params := range cgroupParamsMap {
-		cgroupPidPath := filepath.Join(cgroupPath, controller, pid)
-		if err := os.Mkdir(cgroupPidPath, 0555); err != nil {
-			return fmt.Errorf("error creating %s: %v", cgroupPidPath, err)
-		}
-		if err := configureCgroup(cgroupPidPath, params); err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
-// clean up (remove) the cgroup once the job is finished
-func removeCgroups(pid int) error {
-	var errorStrings []string
-	for controller := range cgroupParamsMap {
-		// path to the cgroup for this process
-		cgroupPidPath := filepath.Join(cgroupPath, controller, strconv.Itoa(pid))
-		if err := os.RemoveAll(cgroupPidPath); err != nil {
-			errorStrings = append(errorStrings, fmt.Sprintf("error removing %s: %v", cgroupPidPath, err.Error()))
-		}
-	}
-	if len(errorStrings) != 0 {
-		return errors.New(strings.Join(errorStrings, " "))
-	}
-	return nil
-}
@@ -1,63 +1,60 @@
 package worker
 
 import (
-	"bytes"
-	"fmt"
-	"os"
-	"path/filepath"
-	"strconv"
+	"context"
+	"time"
 )
 
-// Status returns the current status of a process
+// Status returns the current status of a process. For a running job, Duration is the time
+// since it started and Usage is read from its cgroups.
 func (w *Worker) Status(uuid string) (status Status, err error) {
 	job, err := w.getJobByUUID(uuid)
 	if err != nil {
 		return Status{}, err
 	}
-	// get exited boolean and exitcode with a read lock
+	// get exited boolean, exitcode and whether the job has started with a read lock
 	w.mu.RLock()
-	exited, exitCode := job.status.Exited, job.status.ExitCode
+	exited, exitCode, started, paused := job.status.Exited, job.status.ExitCode, job.cmd != nil, job.paused
 	w.mu.RUnlock()
 
-	var processStat ProcessStat
-	// only try to grab the job status from /proc/<pid>/stat if the job hasn't exited
-	if !exited && exitCode == 0 {
-		processStat, err = parseProcStat(strconv.Itoa(job.pid))
+	var state string
+	// only look up the state of the process if the job hasn't exited
+	if !exited && exitCode == 0 && !started {
+		state = "QUEUED"
+	} else if !exited && exitCode == 0 && paused {
+		state = "PAUSED"
+	} else if !exited && exitCode == 0 {
+		state, err = processState(job.pid)
 		if err != nil {
 			return Status{}, err
 		}
-		switch processStat.State {
-		case "R", "S", "D":
-			processStat.State = "RUNNING"
-		case "Z":
-			processStat.State = "ZOMBIE"
-		case "T":
-			processStat.State = "STOPPED"
-		}
 	} else {
-		processStat.State = "EXITED"
+		state = "EXITED"
 	}
 	w.mu.Lock()
-	job.status.State = processStat.State
+	job.status.State = state
+	// copy under the lock, the archive status can change in the background
+	status = *job.status
 	w.mu.Unlock()
+	if !status.StartedAt.IsZero() && status.FinishedAt.IsZero() {
+		status.Duration = time.Since(status.StartedAt)
+		status.Usage = readUsage(job.pid, job.cgroups)
+	}
 
-	return *job.status, nil
+	return status, nil
 }
 
-// parse the /proc/<pid>/stat file to get information about a process. This is used
-// to get the process state for getProcessState() and the PID for Rexec()
-// Note that pid here is a string because it could be "self"
-// See: /proc/[pid]/stat section of https://man7.org/linux/man-pages/man5/proc.5.html
-func parseProcStat(pid string) (stat ProcessStat, err error) {
-	stats, err := os.ReadFile(filepath.Join("/", "proc", pid, "stat"))
+// Wait blocks until a job has finished and its output file is closed (or the context is
+// done), then returns the final status of the job
+func (w *Worker) Wait(ctx context.Context, uuid string) (Status, error) {
+	job, err := w.getJobByUUID(uuid)
 	if err != nil {
-		return ProcessStat{}, fmt.Errorf("error reading /proc/%s/stat: %v", pid, err)
+		return Status{}, err
 	}
-
-	var ignoreString string
-	if _, err := fmt.Fscan(bytes.NewBuffer(stats), &stat.PID, &ignoreString, &stat.State); err != nil {
-		return ProcessStat{}, err
+	select {
+	case <-job.done:
+	case <-ctx.Done():
+		return Status{}, ctx.Err()
 	}
-
-	return stat, err
+	return w.Status(uuid)
 }
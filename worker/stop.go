@@ -1,23 +1,56 @@
 package worker
 
 import (
+	"errors"
 	"fmt"
-	"syscall"
+	"os"
 )
 
-// Stop terminates a running process
+// Stop terminates a running process. A paused process is resumed, so it acts on the kill.
 func (w *Worker) Stop(uuid string) error {
 	job, err := w.getJobByUUID(uuid)
 	if err != nil {
-		return fmt.Errorf("error getting job: %v", err)
+		return fmt.Errorf("error getting job: %w", err)
+	}
+	// a queued job has no process yet, so just take it off the queue
+	if w.dequeue(job) {
+		return nil
 	}
 
-	if err = job.cmd.Process.Signal(syscall.SIGKILL); err != nil {
+	if err = job.cmd.Process.Kill(); err != nil {
+		if errors.Is(err, os.ErrProcessDone) {
+			return fmt.Errorf("%w: %s", ErrJobAlreadyExited, uuid)
+		}
 		return fmt.Errorf("error killing process: %v", err)
 	}
 	w.mu.Lock()
 	job.status.Terminated = true
+	paused := job.paused
+	job.paused = false
 	w.mu.Unlock()
+	// frozen processes only act on the kill once they are thawed
+	if paused {
+		if err := freeze(job.pid, false); err != nil {
+			return fmt.Errorf("error resuming paused process: %v", err)
+		}
+	}
 
 	return nil
 }
+
+// dequeue removes a job from the queue, marking it as terminated. It returns false
+// if the job was not queued.
+func (w *Worker) dequeue(job *Job) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for i, queued := range w.queue {
+		if queued == job {
+			w.queue = append(w.queue[:i], w.queue[i+1:]...)
+			job.status.Terminated = true
+			job.status.ExitCode = -1
+			close(job.done)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// FileWatcher watches output files so Output can follow a job as it writes
+type FileWatcher interface {
+	// Watch sends an event on the returned channel each time the file at path is written to,
+	// until the context is done. The channel is closed once watching stops.
+	Watch(ctx context.Context, path string) (<-chan struct{}, error)
+}
+
+// defaultPollInterval is how often a polling watcher checks files for changes
+const defaultPollInterval = 100 * time.Millisecond
+
+// NewFileWatcher returns a FileWatcher by kind: "inotify" (Linux only), "poll", or "auto" for
+// inotify falling back to polling when inotify is unavailable or its limits are exhausted
+func NewFileWatcher(kind string) (FileWatcher, error) {
+	switch kind {
+	case "auto", "":
+		return autoWatcher(), nil
+	case "inotify":
+		return newInotifyWatcher()
+	case "poll":
+		return pollingWatcher{interval: defaultPollInterval}, nil
+	}
+	return nil, fmt.Errorf("unknown file watcher %q (expected auto, inotify or poll)", kind)
+}
+
+// pollingWatcher detects writes to a file by checking its size and modification time every
+// interval. It works everywhere, at the cost of latency and a stat call per interval.
+type pollingWatcher struct {
+	interval time.Duration
+}
+
+func (p pollingWatcher) Watch(ctx context.Context, path string) (<-chan struct{}, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	eventStream := make(chan struct{})
+	go func() {
+		defer close(eventStream)
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		size, modTime := info.Size(), info.ModTime()
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				log.Printf("error getting fileinfo on %s: %v", path, err)
+				return
+			}
+			if info.Size() == size && info.ModTime().Equal(modTime) {
+				continue
+			}
+			size, modTime = info.Size(), info.ModTime()
+			select {
+			case eventStream <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return eventStream, nil
+}
+
+// fallbackWatcher watches files with primary, and with secondary for files primary fails to
+// watch (e.g., when the inotify watch or instance limits are exhausted on a busy host)
+type fallbackWatcher struct {
+	primary, secondary FileWatcher
+}
+
+func (f fallbackWatcher) Watch(ctx context.Context, path string) (<-chan struct{}, error) {
+	eventStream, err := f.primary.Watch(ctx, path)
+	if err == nil {
+		return eventStream, nil
+	}
+	log.Printf("error watching %s, falling back: %v", path, err)
+	return f.secondary.Watch(ctx, path)
+}
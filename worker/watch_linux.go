@@ -0,0 +1,89 @@
+package worker
+
+import (
+	"context"
+	"log"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// inotifyWatcher watches files for IN_MODIFY events using inotify, with one inotify instance
+// per watched file
+type inotifyWatcher struct{}
+
+func newInotifyWatcher() (FileWatcher, error) {
+	return inotifyWatcher{}, nil
+}
+
+// autoWatcher returns the default FileWatcher: inotify, falling back to polling for files
+// inotify fails to watch
+func autoWatcher() FileWatcher {
+	return fallbackWatcher{primary: inotifyWatcher{}, secondary: pollingWatcher{interval: defaultPollInterval}}
+}
+
+// Watch watches a file for IN_MODIFY events when it is written to.
+// Note that this will not catch if the file is closed/moved because we are not
+// watching for those events.
+//
+// See:
+// https://linux.die.net/man/1/inotifywait
+// https://pkg.go.dev/github.com/fsnotify/fsnotify
+// https://efreitasn.dev/posts/inotify-api/
+func (inotifyWatcher) Watch(ctx context.Context, outFilePath string) (<-chan struct{}, error) {
+	fd, err := unix.InotifyInit()
+	if err != nil {
+		return nil, err
+	}
+	// add inotifywatch for IN_MODIFY events on a file
+	wd, err := unix.InotifyAddWatch(fd, outFilePath, unix.IN_MODIFY)
+	if err != nil {
+		if err := unix.Close(fd); err != nil {
+			log.Printf("error closing file descriptor: %v", err)
+		}
+		return nil, err
+	}
+
+	// channel for parsing inotify Masks - https://pkg.go.dev/golang.org/x/sys/unix#InotifyEvent
+	eventStream := make(chan struct{})
+	go func() {
+		defer func() {
+			// remove the watch when we're done
+			success, err := unix.InotifyRmWatch(fd, uint32(wd))
+			if success == -1 || err != nil {
+				log.Printf("error removing inotify watch: %v", err)
+			}
+			if err := unix.Close(fd); err != nil {
+				log.Printf("error closing file descriptor: %v", err)
+			}
+			close(eventStream)
+		}()
+
+		// read events from the fd
+		// see "Reading Events" from https://efreitasn.dev/posts/inotify-api/
+		var buf [(unix.SizeofInotifyEvent + unix.NAME_MAX + 1) * 20]byte
+		for {
+			n, err := unix.Read(fd, buf[:])
+			if err != nil {
+				log.Printf("error reading from fd: %v", err)
+				return
+			}
+			offset := 0
+			for offset <= n-unix.SizeofInotifyEvent {
+				rawEvent := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+				offset += unix.SizeofInotifyEvent + int(rawEvent.Len)
+				// if this is not an IN_MODIFY event, continue to next "for" iteration
+				if rawEvent.Mask&unix.IN_MODIFY != unix.IN_MODIFY {
+					continue
+				}
+				// otherwise, send it to the eventStream
+				select {
+				case eventStream <- struct{}{}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return eventStream, nil
+}
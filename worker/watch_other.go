@@ -0,0 +1,15 @@
+//go:build !linux
+
+package worker
+
+import "fmt"
+
+// newInotifyWatcher returns an error, since inotify is only available on Linux
+func newInotifyWatcher() (FileWatcher, error) {
+	return nil, fmt.Errorf("inotify watcher %w", errNotSupported)
+}
+
+// autoWatcher returns the default FileWatcher, which polls outside of Linux
+func autoWatcher() FileWatcher {
+	return pollingWatcher{interval: defaultPollInterval}
+}
@@ -6,33 +6,103 @@ import (
 	"os/exec"
 	"path/filepath"
 	"sync"
+	"time"
 )
 
 type Worker struct {
-	mu     sync.RWMutex    // protects jobs map
-	jobs   map[string]*Job // map of job UUID to Job
-	Config *Config
+	mu       sync.RWMutex    // protects jobs map, running count, queue and draining
+	jobs     map[string]*Job // map of job UUID to Job
+	running  int             // number of jobs currently running (or starting)
+	queue    []*Job          // jobs waiting for a running slot, in FIFO order
+	draining bool            // no new jobs are accepted once the worker is draining
+	Config   *Config
 }
 
 type Config struct {
 	ChunkSize int
 	Outpath   string
+	MaxJobs   int  // maximum number of concurrently running jobs (0 for no limit)
+	QueueJobs bool // queue jobs over the MaxJobs limit instead of rejecting them
+	// flush job output to disk and record its size before reporting the job as exited,
+	// so output read right after a job exits is never truncated
+	SyncOutput bool
+	Watcher    FileWatcher // watches output files while following a job (see NewFileWatcher)
+	// patterns (e.g., "LD_*") of environment variables jobs can't set, and if EnvAllow is not
+	// empty, the only ones they can set
+	EnvDeny  []string
+	EnvAllow []string
+	Archiver Archiver // archives the output of every finished job (nil to keep output local only)
+	// URL prefixes (e.g., "s3://bucket/") of the archive sinks jobs can choose with WithArchive
+	ArchiveAllow []string
+	// patterns (e.g., "/usr/local/scripts/*") of the absolute paths of commands jobs can't run,
+	// and if CommandAllow is not empty, the only ones they can run (see checkCommand)
+	CommandDeny  []string
+	CommandAllow []string
 }
 
 // Job represents an arbitrary Linux process schedule by the Worker
 type Job struct {
-	UUID   string
-	cmd    *exec.Cmd
-	pid    int
-	status *Status
+	UUID    string
+	created time.Time // when the job was submitted to the worker
+	owner   string    // identity of the client that started the job (see WithOwner)
+	parent  string    // UUID of the job this one was restarted from (see Restart)
+	name    string    // command to run
+	args    []string  // arguments to the command
+	env     []string  // environment variables (KEY=value) set in addition to the worker's
+	dir     string    // working directory (the worker's if empty)
+	cmd     *exec.Cmd
+	pid     int
+	status  *Status
+	cgroups CgroupConfig  // cgroup parameters written for the job
+	done    chan struct{} // closed once the job has finished and its output is closed
+	paused  bool          // frozen by Pause
+
+	archiveURL string   // sink requested for the job output (see WithArchive)
+	archiver   Archiver // archives the job output once it finishes (nil if not archived)
 }
 
 // Status of the process
 type Status struct {
-	State      string // RUNNING, STOPPED, ZOMBIE, EXITED
+	State      string // QUEUED, RUNNING, PAUSED, STOPPED, ZOMBIE, EXITED
 	Terminated bool   // Job terminated by the worker API
 	ExitCode   int    // https://pkg.go.dev/os#ProcessState.ExitCode
 	Exited     bool   // https://pkg.go.dev/os#ProcessState.Exited
+	OutputSize int64  // size of the output file in bytes, recorded when the job finishes
+
+	StartedAt  time.Time     // when the process was started (zero while the job is queued)
+	FinishedAt time.Time     // when the process exited (zero while the job is running)
+	Duration   time.Duration // wall-clock run time of the process, so far if it is still running
+
+	Usage Usage // resource usage, recorded when the job finishes and read live while it runs
+
+	ArchiveStatus   string // PENDING, UPLOADING, ARCHIVED, FAILED or empty if output is not archived
+	ArchiveLocation string // where the output was archived
+	ArchiveError    string // why archiving failed
+}
+
+// Usage is the resource usage of a job, read from its cgroups
+type Usage struct {
+	CPUTime      time.Duration // user and system CPU time
+	PeakMemory   int64         // maximum memory usage in bytes
+	IOReadBytes  int64         // bytes read from block devices
+	IOWriteBytes int64         // bytes written to block devices
+}
+
+// CgroupConfig maps cgroup controllers (e.g., "memory") to the parameter files and values
+// written under the controller for a job (e.g., "memory.limit_in_bytes": "32M")
+type CgroupConfig map[string]map[string]string
+
+// JobInfo describes how a job was configured
+type JobInfo struct {
+	UUID    string
+	Created time.Time
+	Owner   string
+	Parent  string // UUID of the job this one was restarted from, if any
+	Name    string
+	Args    []string
+	Env     []string
+	Dir     string
+	Cgroups CgroupConfig
 }
 
 type ProcessStat struct {
@@ -46,6 +116,8 @@ func New() *Worker {
 		Config: &Config{
 			ChunkSize: 1024 * 64,                                 // set default chunk size to 64KB
 			Outpath:   filepath.Join(os.TempDir(), "jobmanager"), // path to the output files, e.g., /tmp/jobmanager
+			Watcher:   autoWatcher(),
+			EnvDeny:   DefaultEnvDeny,
 		},
 	}
 }
@@ -55,7 +127,7 @@ func (w *Worker) getJobByUUID(uuid string) (*Job, error) {
 	defer w.mu.RUnlock()
 	job, ok := w.jobs[uuid]
 	if !ok {
-		return nil, fmt.Errorf("no job with uuid %s found", uuid)
+		return nil, fmt.Errorf("%w: no job with uuid %s", ErrJobNotFound, uuid)
 	}
 	return job, nil
 }
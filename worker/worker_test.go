@@ -4,6 +4,15 @@ import (
 	"context"
 	"crypto/rand"
 	"crypto/sha256"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
 	"testing"
 	"time"
 
@@ -31,6 +40,76 @@ func TestStopJob(t *testing.T) {
 func TestStopBadJob(t *testing.T) {
 	err := worker.Stop(uuid.NewString())
 	assert.NotNil(t, err)
+	assert.ErrorIs(t, err, ErrJobNotFound)
+}
+
+func TestStopExitedJob(t *testing.T) {
+	// run a command to completion and add it as a job so Stop finds an exited process
+	cmd := exec.Command("true")
+	assert.NoError(t, cmd.Run())
+	UUID := uuid.NewString()
+	worker.jobs[UUID] = &Job{UUID: UUID, cmd: cmd, status: &Status{Exited: true}}
+
+	err := worker.Stop(UUID)
+	assert.ErrorIs(t, err, ErrJobAlreadyExited)
+}
+
+func TestSignalJob(t *testing.T) {
+	for _, name := range []string{"HUP", "SIGHUP", "sighup", "1"} {
+		sig, err := ParseSignal(name)
+		assert.NoError(t, err)
+		assert.Equal(t, syscall.SIGHUP, sig)
+	}
+	_, err := ParseSignal("STOP")
+	assert.ErrorIs(t, err, ErrInvalidSignal)
+
+	err = worker.Signal(uuid.NewString(), syscall.SIGHUP)
+	assert.ErrorIs(t, err, ErrJobNotFound)
+
+	cmd := exec.Command("true")
+	assert.NoError(t, cmd.Run())
+	exited := uuid.NewString()
+	worker.jobs[exited] = &Job{UUID: exited, cmd: cmd, status: &Status{Exited: true}}
+	assert.ErrorIs(t, worker.Signal(exited, syscall.SIGHUP), ErrJobAlreadyExited)
+
+	queued := uuid.NewString()
+	worker.jobs[queued] = &Job{UUID: queued, status: &Status{State: "QUEUED"}}
+	assert.ErrorIs(t, worker.Signal(queued, syscall.SIGHUP), ErrJobQueued)
+}
+
+func TestPauseJob(t *testing.T) {
+	assert.ErrorIs(t, worker.Pause(uuid.NewString()), ErrJobNotFound)
+
+	cmd := exec.Command("true")
+	assert.NoError(t, cmd.Run())
+	exited := uuid.NewString()
+	worker.jobs[exited] = &Job{UUID: exited, cmd: cmd, status: &Status{Exited: true}}
+	assert.ErrorIs(t, worker.Pause(exited), ErrJobAlreadyExited)
+	assert.ErrorIs(t, worker.Resume(exited), ErrJobAlreadyExited)
+
+	queued := uuid.NewString()
+	worker.jobs[queued] = &Job{UUID: queued, status: &Status{State: "QUEUED"}}
+	assert.ErrorIs(t, worker.Pause(queued), ErrJobQueued)
+}
+
+func TestRestartJob(t *testing.T) {
+	_, err := worker.Restart(uuid.NewString())
+	assert.ErrorIs(t, err, ErrJobNotFound)
+
+	dir := t.TempDir()
+	UUID, err := worker.Start("ps", []string{"aux"}, WithEnv([]string{"FOO=bar"}), WithDir(dir), WithOwner("client_admin"))
+	assert.NoError(t, err)
+	restarted, err := worker.Restart(UUID, WithOwner("client_other"))
+	assert.NoError(t, err)
+	assert.NotEqual(t, UUID, restarted)
+
+	info, err := worker.Describe(restarted)
+	assert.NoError(t, err)
+	assert.Equal(t, UUID, info.Parent)
+	assert.Equal(t, "client_other", info.Owner)
+	assert.Equal(t, []string{"aux"}, info.Args)
+	assert.Equal(t, []string{"FOO=bar"}, info.Env)
+	assert.Equal(t, dir, info.Dir)
 }
 
 func TestJobStatusRunning(t *testing.T) {
@@ -42,6 +121,9 @@ func TestJobStatusRunning(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, status.State, "RUNNING")
 	assert.Equal(t, false, status.Terminated)
+	assert.False(t, status.StartedAt.IsZero())
+	assert.True(t, status.FinishedAt.IsZero())
+	assert.GreaterOrEqual(t, status.Duration, time.Second)
 
 	err = worker.Stop(UUID)
 	assert.NoError(t, err)
@@ -60,14 +142,203 @@ func TestJobStatusStopped(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, status.State, "EXITED")
 	assert.Equal(t, true, status.Terminated)
+	assert.True(t, status.FinishedAt.After(status.StartedAt))
+	assert.Equal(t, status.FinishedAt.Sub(status.StartedAt), status.Duration)
+	assert.Greater(t, status.Usage.CPUTime, time.Duration(0))
 }
 
 func TestJobStatusBad(t *testing.T) {
 	status, err := worker.Status(uuid.NewString())
-	assert.Error(t, err)
+	assert.ErrorIs(t, err, ErrJobNotFound)
 	assert.Equal(t, Status{}, status)
 }
 
+func TestStartJobLimit(t *testing.T) {
+	limited := New()
+	limited.Config.MaxJobs = 1
+
+	UUID, err := limited.Start("top", []string{})
+	assert.NoError(t, err)
+	defer limited.Stop(UUID)
+
+	_, err = limited.Start("top", []string{})
+	assert.ErrorIs(t, err, ErrJobLimitReached)
+}
+
+// TestStartJobQueued starts two jobs on a worker that can only run one at a time, and checks
+// that the second job is queued and then started once the first one is stopped
+func TestStartJobQueued(t *testing.T) {
+	limited := New()
+	limited.Config.MaxJobs = 1
+	limited.Config.QueueJobs = true
+
+	firstUUID, err := limited.Start("top", []string{})
+	assert.NoError(t, err)
+	secondUUID, err := limited.Start("top", []string{})
+	assert.NoError(t, err)
+
+	status, err := limited.Status(secondUUID)
+	assert.NoError(t, err)
+	assert.Equal(t, "QUEUED", status.State)
+
+	time.Sleep(time.Second)
+	err = limited.Stop(firstUUID)
+	assert.NoError(t, err)
+
+	time.Sleep(time.Second)
+	status, err = limited.Status(secondUUID)
+	assert.NoError(t, err)
+	assert.Equal(t, "RUNNING", status.State)
+
+	err = limited.Stop(secondUUID)
+	assert.NoError(t, err)
+}
+
+// TestDrain checks that a draining worker rejects new jobs and drops queued ones, and that
+// StopAll and WaitAll take care of the jobs still running
+func TestDrain(t *testing.T) {
+	limited := New()
+	limited.Config.MaxJobs = 1
+	limited.Config.QueueJobs = true
+
+	_, err := limited.Start("top", []string{})
+	assert.NoError(t, err)
+	queuedUUID, err := limited.Start("top", []string{})
+	assert.NoError(t, err)
+
+	limited.Drain()
+	assert.True(t, limited.Draining())
+	_, err = limited.Start("top", []string{})
+	assert.ErrorIs(t, err, ErrDraining)
+
+	status, err := limited.Status(queuedUUID)
+	assert.NoError(t, err)
+	assert.True(t, status.Terminated)
+	assert.Equal(t, -1, status.ExitCode)
+
+	assert.Equal(t, 1, limited.StopAll())
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	assert.NoError(t, limited.WaitAll(ctx))
+}
+
+// TestWaitSyncOutput checks that Wait returns the final status of a job, including the
+// output size recorded before the job was reported as exited
+func TestWaitSyncOutput(t *testing.T) {
+	synced := New()
+	synced.Config.SyncOutput = true
+
+	UUID, err := synced.Start("top", []string{})
+	assert.NoError(t, err)
+	time.Sleep(time.Second)
+	err = synced.Stop(UUID)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	status, err := synced.Wait(ctx, UUID)
+	assert.NoError(t, err)
+	assert.Equal(t, "EXITED", status.State)
+	info, err := os.Stat(filepath.Join(synced.Config.Outpath, UUID))
+	assert.NoError(t, err)
+	assert.Equal(t, info.Size(), status.OutputSize)
+}
+
+func TestStartJobInvalidEnv(t *testing.T) {
+	restricted := New()
+	restricted.Config.EnvAllow = []string{"PATH", "APP_*"}
+
+	for _, opts := range [][]Option{
+		{WithEnv([]string{"LD_PRELOAD=/tmp/evil.so"})},   // denied by default
+		{WithEnv([]string{"JOBMANAGER_CGROUPS={}"})},     // reserved
+		{WithEnv([]string{"HOME=/root"})},                // not allowed
+		{WithEnv([]string{"PATH"})},                      // not KEY=value
+		{WithEnv([]string{"PATH=/bin"}), WithDir("tmp")}, // relative working directory
+	} {
+		_, err := restricted.Start("ps", []string{}, opts...)
+		assert.ErrorIs(t, err, ErrInvalidJob)
+	}
+
+	UUID, err := restricted.Start("ps", []string{}, WithEnv([]string{"PATH=/bin", "APP_MODE=test"}), WithDir("/tmp"))
+	assert.NoError(t, err)
+	info, err := restricted.Describe(UUID)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"PATH=/bin", "APP_MODE=test"}, info.Env)
+	assert.Equal(t, "/tmp", info.Dir)
+}
+
+func TestStartJobCommandPolicy(t *testing.T) {
+	ps, err := exec.LookPath("ps")
+	assert.NoError(t, err)
+	ps, err = filepath.EvalSymlinks(ps)
+	assert.NoError(t, err)
+	// a link to ps in a directory that is not allowed
+	link := filepath.Join(t.TempDir(), "ps")
+	assert.NoError(t, os.Symlink(ps, link))
+
+	allowing := New()
+	allowing.Config.CommandAllow = []string{filepath.Dir(ps) + "/*"}
+	UUID, err := allowing.Start("ps", []string{})
+	assert.NoError(t, err)
+	info, err := allowing.Describe(UUID)
+	assert.NoError(t, err)
+	assert.True(t, filepath.IsAbs(info.Name), "the checked path should be the one that runs")
+	_, err = allowing.Start(link, []string{})
+	assert.ErrorIs(t, err, ErrCommandDenied, "links outside the allowed paths are denied")
+	_, err = allowing.Start("/nonexistent/ps", []string{})
+	assert.ErrorIs(t, err, ErrCommandDenied)
+
+	denying := New()
+	denying.Config.CommandDeny = []string{ps}
+	for _, name := range []string{"ps", ps, link} {
+		_, err = denying.Start(name, []string{})
+		assert.ErrorIs(t, err, ErrCommandDenied)
+	}
+}
+
+func TestDescribeJob(t *testing.T) {
+	UUID, err := worker.Start("ps", []string{"aux"})
+	assert.NoError(t, err)
+
+	info, err := worker.Describe(UUID)
+	assert.NoError(t, err)
+	assert.Equal(t, "ps", info.Name)
+	assert.Equal(t, []string{"aux"}, info.Args)
+	assert.Equal(t, defaultCgroupConfig(), info.Cgroups)
+}
+
+func TestStartJobCgroups(t *testing.T) {
+	UUID, err := worker.Start("ps", []string{}, WithCgroups(CgroupConfig{"memory": {"memory.limit_in_bytes": "64M", "memory.swappiness": "0"}}))
+	assert.NoError(t, err)
+	info, err := worker.Describe(UUID)
+	assert.NoError(t, err)
+	assert.Equal(t, "64M", info.Cgroups["memory"]["memory.limit_in_bytes"])
+	assert.Equal(t, "0", info.Cgroups["memory"]["memory.swappiness"])
+	assert.Equal(t, defaultCgroupConfig()["cpu,cpuacct"], info.Cgroups["cpu,cpuacct"])
+
+	_, err = worker.Start("ps", []string{}, WithCgroups(CgroupConfig{"memory": {"../../tasks": "1"}}))
+	assert.ErrorIs(t, err, ErrInvalidJob)
+}
+
+func TestDescribeBadJob(t *testing.T) {
+	_, err := worker.Describe(uuid.NewString())
+	assert.ErrorIs(t, err, ErrJobNotFound)
+}
+
+func TestListJobs(t *testing.T) {
+	lister := New()
+	firstUUID, err := lister.Start("ps", []string{})
+	assert.NoError(t, err)
+	secondUUID, err := lister.Start("ps", []string{"aux"})
+	assert.NoError(t, err)
+
+	jobs := lister.List()
+	assert.Len(t, jobs, 2)
+	// most recently created first
+	assert.Equal(t, secondUUID, jobs[0].UUID)
+	assert.Equal(t, firstUUID, jobs[1].UUID)
+}
+
 // TestOutputJob creates a file under /tmp/jobmanager with 512 bytes of random data using rand.Read().
 // The test function generates a hash of that data and compares it to the output from the Output()
 // method to ensure they match.
@@ -103,10 +374,157 @@ func TestOutputJob(t *testing.T) {
 	assert.EqualValues(t, firstHash, secondHash)
 }
 
+// TestReadOutput reads the output of a job in chunks and checks eof is only set at the end of
+// the output of a finished job
+func TestReadOutput(t *testing.T) {
+	UUID := uuid.NewString()
+	job := &Job{UUID: UUID, status: &Status{}, done: make(chan struct{})}
+	worker.jobs[UUID] = job
+	f, err := createOutFile(UUID)
+	assert.NoError(t, err)
+	defer f.Close()
+	_, err = f.WriteString("0123456789")
+	assert.NoError(t, err)
+
+	data, next, eof, err := worker.ReadOutput(UUID, 0, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, "0123", string(data))
+	assert.Equal(t, int64(4), next)
+	assert.False(t, eof)
+
+	// the job is still running, so the end of the output is not eof
+	data, next, eof, err = worker.ReadOutput(UUID, 8, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, "89", string(data))
+	assert.Equal(t, int64(10), next)
+	assert.False(t, eof)
+
+	close(job.done)
+	data, next, eof, err = worker.ReadOutput(UUID, 8, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, "89", string(data))
+	assert.Equal(t, int64(10), next)
+	assert.True(t, eof)
+
+	_, _, _, err = worker.ReadOutput(UUID, -1, 4)
+	assert.Error(t, err)
+}
+
 func TestOutputJobBad(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
 	dataStream, err := worker.Output(ctx, uuid.NewString())
 	assert.Nil(t, dataStream)
+	assert.ErrorIs(t, err, ErrJobNotFound)
+}
+
+// TestOutputFollowPolling follows the output of a running job with the polling watcher and
+// checks data written after the stream started is sent too
+func TestOutputFollowPolling(t *testing.T) {
+	polling := New()
+	watcher, err := NewFileWatcher("poll")
+	assert.NoError(t, err)
+	polling.Config.Watcher = watcher
+
+	UUID := uuid.NewString()
+	polling.jobs[UUID] = &Job{UUID: UUID, status: &Status{}}
+	f, err := createOutFile(UUID)
+	assert.NoError(t, err)
+	defer f.Close()
+	_, err = f.WriteString("first")
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	dataStream, err := polling.Output(ctx, UUID)
+	assert.NoError(t, err)
+	assert.Equal(t, "first", string(<-dataStream))
+
+	_, err = f.WriteString("second")
+	assert.NoError(t, err)
+	assert.Equal(t, "second", string(<-dataStream))
+}
+
+// failingWatcher is a FileWatcher that can't watch anything, like inotify with exhausted limits
+type failingWatcher struct{}
+
+func (failingWatcher) Watch(context.Context, string) (<-chan struct{}, error) {
+	return nil, errors.New("no space left on device")
+}
+
+func TestFallbackWatcher(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "output")
+	assert.NoError(t, os.WriteFile(path, nil, 0644))
+
+	watcher := fallbackWatcher{primary: failingWatcher{}, secondary: pollingWatcher{interval: 10 * time.Millisecond}}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	events, err := watcher.Watch(ctx, path)
+	assert.NoError(t, err)
+
+	assert.NoError(t, os.WriteFile(path, []byte("data"), 0644))
+	select {
+	case <-events:
+	case <-ctx.Done():
+		t.Fatal("no event after writing to the file")
+	}
+}
+
+func TestNewFileWatcherUnknown(t *testing.T) {
+	_, err := NewFileWatcher("fsevents")
 	assert.Error(t, err)
 }
+
+func TestArchiveOutput(t *testing.T) {
+	var posted []byte
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posted, _ = io.ReadAll(r.Body)
+		w.Header().Set("Location", "http://archive/"+r.Header.Get("X-Job-Uuid"))
+	}))
+	defer sink.Close()
+	dir := t.TempDir()
+
+	for _, sinkURL := range []string{"file://" + dir, sink.URL} {
+		UUID := uuid.NewString()
+		f, err := createOutFile(UUID)
+		assert.NoError(t, err)
+		_, err = f.WriteString("output")
+		assert.NoError(t, err)
+		assert.NoError(t, f.Close())
+
+		archiver, err := NewArchiver(sinkURL)
+		assert.NoError(t, err)
+		job := &Job{UUID: UUID, status: &Status{Exited: true}, archiver: archiver}
+		worker.jobs[UUID] = job
+		worker.archive(job)
+
+		status, err := worker.Status(UUID)
+		assert.NoError(t, err)
+		assert.Equal(t, ArchiveDone, status.ArchiveStatus, status.ArchiveError)
+		if strings.HasPrefix(sinkURL, "file://") {
+			assert.Equal(t, filepath.Join(dir, UUID), status.ArchiveLocation)
+			data, err := os.ReadFile(status.ArchiveLocation)
+			assert.NoError(t, err)
+			assert.Equal(t, "output", string(data))
+		} else {
+			assert.Equal(t, "http://archive/"+UUID, status.ArchiveLocation)
+			assert.Equal(t, "output", string(posted))
+		}
+	}
+}
+
+func TestStartJobArchive(t *testing.T) {
+	dir := t.TempDir()
+	archiving := New()
+	archiving.Config.ArchiveAllow = []string{"file://" + dir + "/"}
+
+	for _, sink := range []string{"http://example.com/upload", "file://" + dir + "/../etc", "file://" + dir + "-other"} {
+		_, err := archiving.Start("ps", []string{}, WithArchive(sink))
+		assert.ErrorIs(t, err, ErrInvalidJob)
+	}
+	UUID, err := archiving.Start("ps", []string{}, WithArchive("file://"+dir+"/team"))
+	assert.NoError(t, err)
+	status, err := archiving.Status(UUID)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, status.ArchiveStatus)
+}